@@ -0,0 +1,287 @@
+// Mailmock - Lighweight SMTP server for testing
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adrienaury/mailmock/pkg/client"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/spf13/pflag"
+)
+
+// Output formats of subcommands.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatEML   = "eml"
+)
+
+// commands are the subcommands querying or controlling a running instance, they return the exit code.
+// The server is started if no subcommand is given.
+var commands = map[string]func(args []string) int{
+	"list":   list,
+	"show":   show,
+	"wait":   wait,
+	"purge":  purge,
+	"export": export,
+	"send":   send,
+	"bench":  bench,
+}
+
+// cli holds the flags shared by all subcommands.
+type cli struct {
+	flags     *pflag.FlagSet
+	url       string
+	namespace string
+	output    string
+	timeout   time.Duration
+	filter    client.Filter
+}
+
+// newFlags creates the flags of a subcommand, usage starts with the name of the subcommand.
+func newFlags(usage string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(strings.Fields(usage)[0], pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mailmock %v\n\n%v", usage, flags.FlagUsages())
+	}
+	return flags
+}
+
+// parseFlags parses the arguments, the returned exit code is positive if the command must stop.
+func parseFlags(flags *pflag.FlagSet, args []string) int {
+	if err := flags.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	return -1
+}
+
+// newCLI creates the flags of a subcommand querying the REST API.
+func newCLI(usage string, output string, timeout time.Duration) *cli {
+	c := &cli{flags: newFlags(usage)}
+	url := os.Getenv("MAILMOCK_URL")
+	if url == "" {
+		url = "http://localhost"
+	}
+	c.flags.StringVar(&c.url, "url", url, "Base URL of the REST API of Mailmock (or MAILMOCK_URL)")
+	c.flags.StringVar(&c.namespace, "ns", "", "Namespace of transactions")
+	c.flags.DurationVar(&c.timeout, "timeout", timeout, "Maximum duration of the command")
+	if output != "" {
+		c.flags.StringVarP(&c.output, "output", "o", output, "Output format (table, json, eml)")
+	}
+	return c
+}
+
+// withFilter adds the flags selecting transactions.
+func (c *cli) withFilter() *cli {
+	c.flags.StringVar(&c.filter.Sender, "from", "", "Select transactions with a sender address containing this value")
+	c.flags.StringVar(&c.filter.Recipient, "to", "", "Select transactions with a recipient address containing this value")
+	c.flags.StringVar((*string)(&c.filter.State), "state", "", "Select transactions in this state (completed, aborted)")
+	return c
+}
+
+// parse parses the arguments, the returned exit code is positive if the command must stop.
+func (c *cli) parse(args []string) int {
+	if code := parseFlags(c.flags, args); code >= 0 {
+		return code
+	}
+	switch c.output {
+	case "", formatTable, formatJSON, formatEML:
+		return -1
+	}
+	fmt.Fprintf(os.Stderr, "invalid output format %q\n", c.output)
+	return 2
+}
+
+func (c *cli) client() *client.Client {
+	return client.New(c.url).Namespace(c.namespace)
+}
+
+func (c *cli) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// fail prints the error and returns the exit code.
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	return 1
+}
+
+func list(args []string) int {
+	c := newCLI("list [filters] [-o table|json]", formatTable, 10*time.Second).withFilter()
+	if code := c.parse(args); code >= 0 {
+		return code
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	trs, err := c.client().Search(ctx, c.filter)
+	if err != nil {
+		return fail(err)
+	}
+	if err := write(os.Stdout, c.output, trs); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func show(args []string) int {
+	c := newCLI("show <ID> [-o eml|json|table]", formatEML, 10*time.Second)
+	if code := c.parse(args); code >= 0 {
+		return code
+	}
+	if c.flags.NArg() != 1 {
+		c.flags.Usage()
+		return 2
+	}
+	id, err := strconv.Atoi(c.flags.Arg(0))
+	if err != nil {
+		return fail(fmt.Errorf("invalid ID %q", c.flags.Arg(0)))
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	tr, err := c.client().Get(ctx, id)
+	if err != nil {
+		return fail(fmt.Errorf("transaction %v: %w", id, err))
+	}
+	if c.output == formatJSON {
+		err = writeJSON(os.Stdout, tr)
+	} else {
+		err = write(os.Stdout, c.output, map[int]*smtpd.Transaction{id: tr})
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func wait(args []string) int {
+	c := newCLI("wait [filters] [--timeout 30s] [-o table|json|eml]", formatTable, 30*time.Second).withFilter()
+	if code := c.parse(args); code >= 0 {
+		return code
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	id, tr, err := c.client().Wait(ctx, c.filter)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fail(fmt.Errorf("no transaction received after %v", c.timeout))
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if err := write(os.Stdout, c.output, map[int]*smtpd.Transaction{id: tr}); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func purge(args []string) int {
+	c := newCLI("purge [filters]", "", 10*time.Second).withFilter()
+	if code := c.parse(args); code >= 0 {
+		return code
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	ids, err := c.client().DeleteAll(ctx, c.filter)
+	if err != nil {
+		return fail(err)
+	}
+	if c.filter == (client.Filter{}) {
+		fmt.Println("All transactions purged")
+	} else {
+		fmt.Printf("%v transaction(s) deleted\n", len(ids))
+	}
+	return 0
+}
+
+func export(args []string) int {
+	c := newCLI("export [filters] [--mbox|--json]", "", time.Minute).withFilter()
+	asMbox := c.flags.Bool("mbox", false, "Export in mbox format (default)")
+	asJSON := c.flags.Bool("json", false, "Export in JSON format")
+	if code := c.parse(args); code >= 0 {
+		return code
+	}
+	if *asMbox && *asJSON {
+		fmt.Fprintln(os.Stderr, "--mbox and --json can't be used together")
+		return 2
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	if !*asJSON {
+		if err := c.client().Export(ctx, os.Stdout, c.filter); err != nil {
+			return fail(err)
+		}
+		return 0
+	}
+	trs, err := c.client().Search(ctx, c.filter)
+	if err != nil {
+		return fail(err)
+	}
+	if err := write(os.Stdout, formatJSON, trs); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// write prints the transactions in the given format, the JSON format is always an object with IDs as keys.
+func write(w io.Writer, format string, trs map[int]*smtpd.Transaction) error {
+	ids := make([]int, 0, len(trs))
+	for id := range trs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	switch format {
+	case formatJSON:
+		return writeJSON(w, trs)
+	case formatEML:
+		if len(trs) != 1 {
+			return errors.New("eml output is only available for a single transaction, use export for many")
+		}
+		return client.WriteEML(w, trs[ids[0]].Mail)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tRECEIVED\tFROM\tTO\tSUBJECT")
+	for _, id := range ids {
+		tr := trs[id]
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", id, tr.State, tr.Received.Format(time.RFC3339),
+			tr.Mail.Envelope.Sender, strings.Join(tr.Mail.Envelope.Recipients, ","), tr.Mail.Header("Subject"))
+	}
+	return tw.Flush()
+}
+
+// writeJSON prints the value as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/pkg/client"
+	"github.com/adrienaury/mailmock/pkg/mailmocktest"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+// serve starts a server with two transactions, sent to alice and bob.
+func serve(t *testing.T) *mailmocktest.Server {
+	srv := mailmocktest.Start(t)
+	for _, to := range []string{"alice@example.net", "bob@example.net"} {
+		msg := fmt.Sprintf("Subject: Hello %v\r\nTo: %v\r\n\r\nThis is a test\r\n", to, to)
+		assert.NoError(t, smtp.SendMail(srv.SMTPAddr, nil, "sender@example.org", []string{to}, []byte(msg)), "")
+	}
+	srv.WaitForMessages(t, 2, 5*time.Second)
+	return srv
+}
+
+func TestWriteJSON(t *testing.T) {
+	srv := serve(t)
+
+	for filter, n := range map[client.Filter]int{{}: 2, {Recipient: "bob"}: 1, {Recipient: "carol"}: 0} {
+		trs, err := srv.Client().Search(context.Background(), filter)
+		assert.NoError(t, err, "")
+
+		var b bytes.Buffer
+		assert.NoError(t, write(&b, formatJSON, trs), "")
+		decoded := map[int]*smtpd.Transaction{}
+		assert.NoError(t, json.Unmarshal(b.Bytes(), &decoded), "JSON output MUST always be an object with IDs as keys")
+		assert.Len(t, decoded, n, "")
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	srv := serve(t)
+
+	trs, err := srv.Client().Search(context.Background(), client.Filter{Recipient: "bob"})
+	assert.NoError(t, err, "")
+
+	var b bytes.Buffer
+	assert.NoError(t, write(&b, formatTable, trs), "")
+	assert.Contains(t, b.String(), "Hello bob@example.net", "")
+	assert.NotContains(t, b.String(), "Hello alice@example.net", "")
+}
+
+func TestWriteEML(t *testing.T) {
+	srv := serve(t)
+
+	trs, err := srv.Client().Search(context.Background(), client.Filter{})
+	assert.NoError(t, err, "")
+
+	var b bytes.Buffer
+	assert.Error(t, write(&b, formatEML, trs), "EML output MUST be refused for many transactions")
+
+	trs, err = srv.Client().Search(context.Background(), client.Filter{Recipient: "alice"})
+	assert.NoError(t, err, "")
+	assert.NoError(t, write(&b, formatEML, trs), "")
+	assert.Contains(t, b.String(), "Subject: Hello alice@example.net\r\n", "")
+}
+
+func TestExportFlags(t *testing.T) {
+	assert.Equal(t, 2, export([]string{"--mbox", "--json"}), "--mbox and --json MUST NOT be used together")
+}
@@ -0,0 +1,273 @@
+// Mailmock - Lighweight SMTP server for testing
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/internal/namespace"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/spf13/viper"
+)
+
+// user is an account accepted by a listener.
+type user struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// listenerConfig is the configuration of a SMTP listener, data options not set default to the global parameters.
+type listenerConfig struct {
+	Name           string            `mapstructure:"name"`
+	Address        string            `mapstructure:"address"`
+	Port           string            `mapstructure:"port"`
+	Socket         string            `mapstructure:"socket"` // path of a Unix domain socket, instead of address and port
+	LMTP           bool              `mapstructure:"lmtp"`
+	Banner         []string          `mapstructure:"banner"`
+	TraceHeaders   *bool             `mapstructure:"traceHeaders"`
+	MissingHeaders *bool             `mapstructure:"missingHeaders"`
+	StrictData     *bool             `mapstructure:"strictData"`
+	AuthRequired   bool              `mapstructure:"authRequired"`
+	Users          []user            `mapstructure:"users"` // accepted accounts, any credentials are accepted if empty
+	TLSCert        string            `mapstructure:"tlsCert"`
+	TLSKey         string            `mapstructure:"tlsKey"`
+	ImplicitTLS    bool              `mapstructure:"implicitTLS"`
+	ProxyTrusted   []string          `mapstructure:"proxyTrusted"`   // defaults to the global parameter
+	XClientTrusted []string          `mapstructure:"xclientTrusted"` // defaults to the global parameter
+	Hostname       string            `mapstructure:"hostname"`       // defaults to the global parameter
+	Replies        map[string]string `mapstructure:"replies"`        // added to the global replies
+	Extensions     []string          `mapstructure:"extensions"`     // defaults to the global parameter
+}
+
+// newListeners creates the SMTP servers given by the listeners parameter of the configuration file,
+// or the main server given by the smtpPort or smtpSocket parameters if there is none.
+func newListeners(logger log.Logger) ([]*smtpd.Server, error) {
+	configs := []listenerConfig{}
+	if err := viper.UnmarshalKey("listeners", &configs); err != nil {
+		return nil, fmt.Errorf("invalid listeners configuration: %s", err)
+	}
+	if len(configs) == 0 {
+		configs = append(configs, listenerConfig{
+			Name:    "main",
+			Address: viper.GetString("address"),
+			Port:    viper.GetString("smtpPort"),
+			Socket:  viper.GetString("smtpSocket"),
+			LMTP:    viper.GetBool("lmtp"),
+		})
+	}
+
+	servers := []*smtpd.Server{}
+	for i, config := range configs {
+		srv, err := newListener(config, i, logger)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, srv)
+	}
+	return servers, nil
+}
+
+func newListener(config listenerConfig, i int, logger log.Logger) (*smtpd.Server, error) {
+	if config.Address == "" {
+		config.Address = viper.GetString("address")
+	}
+	if config.Name == "" {
+		config.Name = fmt.Sprintf("listener-%v", i+1)
+	}
+	if config.Socket == "" && config.Port == "" {
+		return nil, fmt.Errorf("listener %v: port or socket is required", config.Name)
+	}
+
+	options := smtpd.Options{
+		TraceHeaders:   inherit(config.TraceHeaders, "traceHeaders"),
+		MissingHeaders: inherit(config.MissingHeaders, "missingHeaders"),
+		StrictData:     inherit(config.StrictData, "strictData"),
+		LMTP:           config.LMTP,
+		AuthRequired:   config.AuthRequired,
+		ImplicitTLS:    config.ImplicitTLS,
+		Banner:         config.Banner,
+	}
+	var err error
+	if options.ProxyTrusted, err = trustedNetworks(config.ProxyTrusted, "proxyTrusted"); err != nil {
+		return nil, fmt.Errorf("listener %v: %s", config.Name, err)
+	}
+	if options.XClientTrusted, err = trustedNetworks(config.XClientTrusted, "xclientTrusted"); err != nil {
+		return nil, fmt.Errorf("listener %v: %s", config.Name, err)
+	}
+	if config.TLSCert != "" || config.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("listener %v: %s", config.Name, err)
+		}
+		options.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	if options.ImplicitTLS && options.TLS == nil {
+		return nil, fmt.Errorf("listener %v: implicit TLS requires a certificate (tlsCert and tlsKey)", config.Name)
+	}
+	if len(config.Users) > 0 {
+		users := config.Users
+		options.Authenticator = func(username, password string) bool {
+			for _, u := range users {
+				if u.Username == username && u.Password == password {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	replies, err := newReplies(config)
+	if err != nil {
+		return nil, fmt.Errorf("listener %v: %s", config.Name, err)
+	}
+
+	port := config.Port
+	if config.Socket != "" {
+		port = config.Name
+	}
+	namespaceOf, err := namespace.Parse(viper.GetString("namespace"), port)
+	if err != nil {
+		return nil, err
+	}
+	srv := smtpd.NewServer(config.Name, config.Address, config.Port, nil, logger)
+	if config.Socket != "" {
+		srv = smtpd.NewUnixServer(config.Name, config.Socket, nil, logger)
+	}
+	srv.SetOptions(options)
+	srv.SetReplies(replies)
+	srv.SetChain(newChain(namespaceOf))
+	srv.SetSessionHandler(&sh)
+	srv.SetCommandHandler(&ch)
+	return srv, nil
+}
+
+// newReplies returns the replies of a listener, its own replies are added to the global ones.
+func newReplies(config listenerConfig) (smtpd.Replies, error) {
+	replies := smtpd.Replies{
+		Hostname: viper.GetString("hostname"),
+		Responses: map[smtpd.Resp]smtpd.Response{
+			smtpd.Ready: {Code: smtpd.CodeReady, Msg: []string{
+				fmt.Sprintf("<domain> Mailmock %v Service ready", version),
+				"This is a testing SMTP server, it does not deliver e-mails",
+			}},
+			smtpd.Help: {Code: smtpd.CodeHelp, Msg: []string{
+				"This is a testing SMTP server, it does not deliver e-mails",
+				"Visit https://github.com/adrienaury/mailmock for more information",
+			}},
+		},
+	}
+	if extensions := viper.GetStringSlice("extensions"); len(extensions) > 0 {
+		replies.Extensions = extensions
+	}
+	if reply := viper.GetString("bareLineEndingReply"); reply != "" {
+		response, err := smtpd.ParseReply(reply)
+		if err == nil && response.Code < 400 {
+			err = fmt.Errorf("invalid reply %q, code must be between 400 and 599", reply)
+		}
+		if err != nil {
+			return replies, err
+		}
+		if err := setReply(replies.Responses, "bareLineEnding", reply); err != nil {
+			return replies, err
+		}
+	}
+	for name, reply := range viper.GetStringMapString("replies") {
+		if err := setReply(replies.Responses, name, reply); err != nil {
+			return replies, err
+		}
+	}
+	for name, reply := range config.Replies {
+		if err := setReply(replies.Responses, name, reply); err != nil {
+			return replies, err
+		}
+	}
+	if config.Hostname != "" {
+		replies.Hostname = config.Hostname
+	}
+	if config.Extensions != nil {
+		replies.Extensions = config.Extensions
+	}
+	return replies, nil
+}
+
+// setReply parses a reply given as "<code> <text>", the previous text is kept if not given.
+func setReply(responses map[smtpd.Resp]smtpd.Response, name string, reply string) error {
+	resp, err := smtpd.ParseResp(name)
+	if err != nil {
+		return err
+	}
+	response, err := smtpd.ParseReply(strings.TrimSpace(reply))
+	if err != nil {
+		return err
+	}
+	if len(response.Msg) == 0 || response.Msg[0] == "" {
+		previous, ok := responses[resp]
+		if !ok {
+			previous = smtpd.DefaultReplies().Responses[resp]
+		}
+		response.Msg = previous.Msg
+	}
+	responses[resp] = *response
+	return nil
+}
+
+// trustedNetworks parses the list if set, or the global parameter.
+func trustedNetworks(values []string, name string) ([]*net.IPNet, error) {
+	if values == nil {
+		values = viper.GetStringSlice(name)
+	}
+	return parseNetworks(values)
+}
+
+// parseNetworks parses networks in CIDR notation, or IP addresses.
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// inherit returns the value if set, or the global parameter.
+func inherit(value *bool, name string) bool {
+	if value != nil {
+		return *value
+	}
+	return viper.GetBool(name)
+}
@@ -19,15 +19,25 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/adrienaury/mailmock/internal/broker"
 	"github.com/adrienaury/mailmock/internal/httpd"
 	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/internal/metrics"
+	"github.com/adrienaury/mailmock/internal/namespace"
+	"github.com/adrienaury/mailmock/internal/relay"
 	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/internal/rule"
+	"github.com/adrienaury/mailmock/internal/tracing"
+	"github.com/adrienaury/mailmock/internal/webhook"
 	"github.com/adrienaury/mailmock/pkg/smtpd"
 	"github.com/heptio/workgroup"
 	"github.com/sirupsen/logrus"
@@ -45,11 +55,82 @@ var (
 	builtBy   string
 )
 
-var th smtpd.TransactionHandler = func(tr *smtpd.Transaction) {
-	repository.Store(tr)
+// notifier posts completed transactions to webhooks
+var notifier *webhook.Notifier
+
+// rules can reject completed transactions
+var rules *rule.Rules
+
+// relayer forwards accepted transactions to real SMTP servers
+var relayer *relay.Relay
+
+// newChain returns the chain of handlers of the transactions of a listener, namespaceOf returns the namespaces in
+// which a transaction is stored. Rules can reject completed transactions and accepted ones are relayed, then every
+// ended transaction is counted, traced, stored and posted to webhooks.
+func newChain(namespaceOf namespace.Func) *smtpd.Chain {
+	var mutex sync.Mutex
+	stored := map[*smtpd.Transaction]map[string]int{} // IDs of stored transactions, until they are posted
+	return smtpd.NewChain().
+		Use("rules", rules.Handler()).
+		Use("relay", relayer.Handler()).
+		UseAlways("metrics", func(tr *smtpd.Transaction) error {
+			metrics.Transaction(tr)
+			return nil
+		}).
+		UseAlways("tracing", func(tr *smtpd.Transaction) error {
+			tracing.Transaction(tr)
+			return nil
+		}).
+		UseAlways("store", func(tr *smtpd.Transaction) error {
+			ids := map[string]int{}
+			for _, ns := range namespaceOf(tr) {
+				id := repository.Namespace(ns).Store(tr)
+				ids[ns] = id
+				broker.Publish(broker.Event{Type: broker.TypeTransaction, Namespace: ns, ID: id, Data: tr})
+			}
+			mutex.Lock()
+			stored[tr] = ids
+			mutex.Unlock()
+			return nil
+		}).
+		UseAlways("webhook", func(tr *smtpd.Transaction) error {
+			mutex.Lock()
+			ids := stored[tr]
+			delete(stored, tr)
+			mutex.Unlock()
+			notifier.Notify(tr, ids)
+			return nil
+		})
+}
+
+var sh smtpd.SessionHandler = func(s *smtpd.Session, e smtpd.SessionEvent) {
+	metrics.SessionEvent(s, e)
+	tracing.SessionEvent(s, e)
+	if e == smtpd.SEClosed {
+		repository.Sessions().Store(s)
+	}
+	broker.Publish(broker.Event{Type: broker.TypeSession, Data: map[string]interface{}{
+		"event":       e,
+		"session":     s.ID,
+		"state":       s.State,
+		"client":      s.Client,
+		"extended":    s.Extended,
+		"remoteAddr":  s.RemoteAddr,
+		"closeReason": s.CloseReason,
+	}})
+}
+
+var ch smtpd.CommandHandler = func(s *smtpd.Session, name string, res *smtpd.Response, duration time.Duration) {
+	metrics.Command(s, name, res, duration)
+	tracing.Command(s, name, res, duration)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
 
 	fmt.Printf(`
      __  __       _ _                      _
@@ -70,7 +151,29 @@ func main() {
 	flag.String("httpPort", "http", "HTTP Port")
 	flag.String("smtpPort", "smtp", "SMTP Port")
 	flag.String("address", "", "Listening address")
+	flag.String("smtpSocket", "", "Listen on this Unix domain socket instead of the SMTP port")
+	flag.Bool("lmtp", false, "Serve LMTP instead of SMTP")
+	flag.String("pop3Port", "", "Serve received mails over POP3 on this port, each recipient address is a mailbox (disabled if empty)")
+	flag.String("pop3TLSCert", "", "Certificate file offered with STLS by the POP3 server")
+	flag.String("pop3TLSKey", "", "Private key file of the POP3 certificate")
+	pflag.StringSlice("proxyTrusted", nil, "Read PROXY protocol headers from these networks or addresses (e.g. 10.0.0.0/8)")
+	pflag.StringSlice("xclientTrusted", nil, "Accept XCLIENT and XFORWARD commands from these networks or addresses (e.g. 127.0.0.1)")
 	flag.String("logLevel", "info", "Log level (trace, debug, info, warn, error)")
+	flag.String("namespace", "", "Derive namespaces of transactions from (domain, header:<Name>, port, auth)")
+	flag.Int("maxCount", 0, "Maximum number of transactions stored by namespace (0 = unlimited)")
+	flag.Int("maxBytes", 0, "Maximum size in bytes of transactions stored by namespace (0 = unlimited)")
+	flag.Duration("ttl", 0, "Maximum age of stored transactions (0 = unlimited)")
+	flag.Int("metricsNamespaces", 20, "Maximum number of namespaces labelled in metrics, others are counted as \"other\"")
+	flag.Bool("traceHeaders", false, "Prepend Return-Path and Received headers to received mails")
+	flag.Bool("missingHeaders", false, "Add Message-ID and Date headers to received mails if missing")
+	flag.Bool("strictData", false, "Reject data with bare CR or LF, or lines longer than 1000 octets")
+	flag.String("bareLineEndingReply", "", "Reply to data with bare CR or LF in strict mode (e.g. \"550 Bare LF\")")
+	flag.String("hostname", "", "Name of the server in SMTP replies and trace headers (default is the name of the machine)")
+	pflag.StringSlice("extensions", nil, "Extensions advertised in reply to EHLO, besides those of enabled features (default HELP)")
+	pflag.StringSlice("webhook", nil, "Post completed transactions to these URLs")
+	flag.String("webhookSecret", "", "Sign payloads posted to webhooks with this key")
+	flag.String("relay", "", "Forward accepted mails to this SMTP server (host:port)")
+	flag.String("otlpEndpoint", "", "Export traces over OTLP/HTTP to this endpoint (e.g. http://localhost:4318)")
 	flag.StringVar(&cfgFile, "config", "", "Configuration file")
 
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
@@ -87,14 +190,102 @@ func main() {
 	if err := viper.BindEnv("address"); err != nil {
 		panic(fmt.Errorf("failed to bind environment variable: %s", err))
 	}
+	if err := viper.BindEnv("smtpSocket"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("lmtp"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("pop3Port"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("pop3TLSCert"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("pop3TLSKey"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("proxyTrusted"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("xclientTrusted"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
 	if err := viper.BindEnv("logLevel"); err != nil {
 		panic(fmt.Errorf("failed to bind environment variable: %s", err))
 	}
+	if err := viper.BindEnv("namespace"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("maxCount"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("maxBytes"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("ttl"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("metricsNamespaces"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("traceHeaders"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("missingHeaders"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("strictData"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("bareLineEndingReply"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("hostname"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("extensions"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("webhook"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("webhookSecret"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("relay"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
+	if err := viper.BindEnv("otlpEndpoint"); err != nil {
+		panic(fmt.Errorf("failed to bind environment variable: %s", err))
+	}
 
 	viper.SetDefault("httpPort", "http")
 	viper.SetDefault("smtpPort", "smtp")
 	viper.SetDefault("address", "")
+	viper.SetDefault("smtpSocket", "")
+	viper.SetDefault("lmtp", false)
+	viper.SetDefault("pop3Port", "")
+	viper.SetDefault("pop3TLSCert", "")
+	viper.SetDefault("pop3TLSKey", "")
+	viper.SetDefault("proxyTrusted", []string{})
+	viper.SetDefault("xclientTrusted", []string{})
 	viper.SetDefault("logLevel", "info")
+	viper.SetDefault("namespace", "")
+	viper.SetDefault("maxCount", 0)
+	viper.SetDefault("maxBytes", 0)
+	viper.SetDefault("ttl", 0)
+	viper.SetDefault("metricsNamespaces", 20)
+	viper.SetDefault("traceHeaders", false)
+	viper.SetDefault("missingHeaders", false)
+	viper.SetDefault("strictData", false)
+	viper.SetDefault("bareLineEndingReply", "")
+	viper.SetDefault("hostname", "")
+	viper.SetDefault("extensions", []string{})
+	viper.SetDefault("webhook", []string{})
+	viper.SetDefault("webhookSecret", "")
+	viper.SetDefault("relay", "")
+	viper.SetDefault("otlpEndpoint", "")
 
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
@@ -118,21 +309,18 @@ func main() {
 		}
 	}
 
-	smtpPort := viper.GetString("smtpPort")
 	httpPort := viper.GetString("httpPort")
 	listenAddr := viper.GetString("address")
 	logLevel, err := logrus.ParseLevel(viper.GetString("logLevel"))
 	if err != nil {
 		panic(err)
 	}
-
-	// sets the SMTP greeting banner
-	smtpd.SetReply(smtpd.Ready,
-		fmt.Sprintf("<domain> Mailmock %v Service ready", version),
-		"This is a testing SMTP server, it does not deliver e-mails")
-	smtpd.SetReply(smtpd.Help,
-		"This is a testing SMTP server, it does not deliver e-mails",
-		"Visit https://github.com/adrienaury/mailmock for more information")
+	repository.SetRetention(repository.Retention{
+		MaxCount: viper.GetInt("maxCount"),
+		MaxBytes: viper.GetInt("maxBytes"),
+		TTL:      viper.GetDuration("ttl"),
+	})
+	metrics.SetNamespaceLimit(viper.GetInt("metricsNamespaces"))
 
 	// logrus initialization
 	logrus.SetFormatter(&logrus.TextFormatter{})
@@ -150,6 +338,44 @@ func main() {
 		log.FieldBuiltBy:   builtBy,
 	})
 
+	shutdownTracing, err := tracing.Setup(context.Background(), viper.GetString("otlpEndpoint"), version, func(err error) {
+		logger.Warn("Failed to export traces", log.Fields{log.FieldError: err})
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	hooks := []webhook.Config{}
+	if err := viper.UnmarshalKey("webhooks", &hooks); err != nil {
+		panic(fmt.Errorf("invalid webhooks configuration: %s", err))
+	}
+	for _, url := range viper.GetStringSlice("webhook") {
+		hooks = append(hooks, webhook.Config{URL: url, Secret: viper.GetString("webhookSecret")})
+	}
+	notifier = webhook.New(hooks, logger.WithFields(log.Fields{
+		log.FieldService: "webhook",
+	}))
+
+	rulesConfig := []rule.Rule{}
+	if err := viper.UnmarshalKey("rules", &rulesConfig); err != nil {
+		panic(fmt.Errorf("invalid rules configuration: %s", err))
+	}
+	rules, err = rule.New(rulesConfig)
+	if err != nil {
+		panic(fmt.Errorf("invalid rules configuration: %s", err))
+	}
+
+	relays := []relay.Config{}
+	if err := viper.UnmarshalKey("relays", &relays); err != nil {
+		panic(fmt.Errorf("invalid relays configuration: %s", err))
+	}
+	if server := viper.GetString("relay"); server != "" {
+		relays = append(relays, relay.Config{Server: server})
+	}
+	relayer = relay.New(relays, logger.WithFields(log.Fields{
+		log.FieldService: "relay",
+	}))
+
 	loggerSMTP := logger.WithFields(log.Fields{
 		log.FieldService: "smtp",
 	})
@@ -158,6 +384,10 @@ func main() {
 		log.FieldService: "http",
 	})
 
+	loggerPOP3 := logger.WithFields(log.Fields{
+		log.FieldService: "pop3",
+	})
+
 	group := &workgroup.Group{}
 	group.Add(func(stop <-chan struct{}) error {
 		// interrupt/kill signals sent from terminal or host on shutdown
@@ -171,15 +401,52 @@ func main() {
 			return nil
 		}
 	})
+	smtpsrvs, err := newListeners(loggerSMTP)
+	if err != nil {
+		panic(err)
+	}
+	httpsrv := httpd.NewServer("main", listenAddr, httpPort, loggerHTTP)
+	httpsrv.SetRelay(relayer)
+	for _, smtpsrv := range smtpsrvs {
+		smtpsrv := smtpsrv
+		httpsrv.AddListener(smtpsrv)
+		group.Add(func(stop <-chan struct{}) error {
+			return smtpsrv.ListenAndServe(stop)
+		})
+	}
+	pop3srv, err := newPOP3Server(loggerPOP3)
+	if err != nil {
+		panic(err)
+	}
+	if pop3srv != nil {
+		group.Add(func(stop <-chan struct{}) error {
+			return pop3srv.ListenAndServe(stop)
+		})
+	}
 	group.Add(func(stop <-chan struct{}) error {
-		smtpsrv := smtpd.NewServer("main", listenAddr, smtpPort, &th, loggerSMTP)
-		return smtpsrv.ListenAndServe(stop)
-	})
-	group.Add(func(stop <-chan struct{}) error {
-		httpsrv := httpd.NewServer("main", listenAddr, httpPort, loggerHTTP)
 		return httpsrv.ListenAndServe(stop)
 	})
+	if ttl := viper.GetDuration("ttl"); ttl > 0 {
+		// expired transactions are evicted even from namespaces which are never read
+		interval := time.Minute
+		if ttl < interval {
+			interval = ttl
+		}
+		group.Add(func(stop <-chan struct{}) error {
+			repository.Sweep(interval, stop)
+			return nil
+		})
+	}
 	err = group.Run()
+	if !relayer.Wait(30 * time.Second) {
+		logger.Warn("Mails still being relayed are dropped")
+	}
+	if !notifier.Wait(30 * time.Second) {
+		logger.Warn("Webhook deliveries still in progress are dropped")
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Warn("Failed to export traces", log.Fields{log.FieldError: err})
+	}
 	if err != nil {
 		logger.Error("Program exited with error", log.Fields{log.FieldError: err})
 		os.Exit(1)
@@ -0,0 +1,56 @@
+// Mailmock - Lighweight SMTP server for testing
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/internal/pop3d"
+	"github.com/spf13/viper"
+)
+
+// newPOP3Server creates the POP3 server given by the pop3Port parameter, nil if it is not set.
+func newPOP3Server(logger log.Logger) (*pop3d.Server, error) {
+	port := viper.GetString("pop3Port")
+	if port == "" {
+		return nil, nil
+	}
+	srv := pop3d.NewServer("pop3", viper.GetString("address"), port, pop3d.NewRepositoryMaildrop(), logger)
+
+	users := []user{}
+	if err := viper.UnmarshalKey("pop3Users", &users); err != nil {
+		return nil, fmt.Errorf("invalid POP3 users configuration: %s", err)
+	}
+	passwords := map[string]string{}
+	for _, u := range users {
+		passwords[u.Username] = u.Password
+	}
+	srv.SetUsers(passwords)
+
+	if cert, key := viper.GetString("pop3TLSCert"), viper.GetString("pop3TLSKey"); cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("POP3 server: %s", err)
+		}
+		srv.SetTLS(&tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12})
+	}
+	return srv, nil
+}
@@ -0,0 +1,353 @@
+// Mailmock - Lighweight SMTP server for testing
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// smtpFlags holds the flags of the subcommands sending mails.
+type smtpFlags struct {
+	server   string
+	helo     string
+	from     string
+	to       []string
+	startTLS bool
+	insecure bool
+	username string
+	password string
+	timeout  time.Duration
+}
+
+func (f *smtpFlags) register(flags *pflag.FlagSet, to []string) {
+	server := os.Getenv("MAILMOCK_SMTP")
+	if server == "" {
+		server = "localhost:25"
+	}
+	flags.StringVar(&f.server, "server", server, "Address and port of the SMTP server (or MAILMOCK_SMTP)")
+	flags.StringVar(&f.helo, "helo", "localhost", "Name sent with EHLO")
+	flags.StringVar(&f.from, "from", "mailmock@localhost", "Sender address")
+	flags.StringSliceVar(&f.to, "to", to, "Recipient addresses, comma separated or repeated")
+	flags.BoolVar(&f.startTLS, "starttls", false, "Upgrade the connection with STARTTLS")
+	flags.BoolVar(&f.insecure, "insecure", false, "Don't verify the certificate of the server")
+	flags.StringVar(&f.username, "auth-user", "", "Authenticate with this username (AUTH PLAIN or LOGIN)")
+	flags.StringVar(&f.password, "auth-password", "", "Password used to authenticate")
+	flags.DurationVar(&f.timeout, "timeout", 30*time.Second, "Maximum duration of each SMTP command")
+}
+
+// connect opens a session, ready to send mails.
+func (f *smtpFlags) connect(transcript io.Writer) (*smtpClient, error) {
+	c, err := dialSMTP(f.server, f.timeout, transcript)
+	if err != nil {
+		return nil, err
+	}
+	err = c.hello(f.helo)
+	if err == nil && f.startTLS {
+		host, _, _ := net.SplitHostPort(f.server)
+		if err = c.startTLS(&tls.Config{ServerName: host, InsecureSkipVerify: f.insecure}); err == nil { // nolint: gosec
+			err = c.hello(f.helo)
+		}
+	}
+	if err == nil && f.username != "" {
+		err = c.auth(f.username, f.password)
+	}
+	if err != nil {
+		c.text.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func send(args []string) int {
+	var f smtpFlags
+	flags := newFlags("send --to <address> [--subject s] [--header 'Name: value'] [--body s|--body-file path] [--attach path]")
+	f.register(flags, nil)
+	subject := flags.String("subject", "Test mail sent by Mailmock", "Subject of the mail")
+	headers := flags.StringArray("header", nil, "Additional header field (e.g. 'X-Test: 1'), can be repeated")
+	body := flags.String("body", "This is a test mail sent by Mailmock.", "Body of the mail")
+	bodyFile := flags.String("body-file", "", "Read the body of the mail from this file (- for stdin)")
+	attachments := flags.StringArray("attach", nil, "Attach this file, can be repeated")
+	quiet := flags.BoolP("quiet", "q", false, "Don't print the transcript of the session")
+	if code := parseFlags(flags, args); code >= 0 {
+		return code
+	}
+	if len(f.to) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	text := *body
+	if *bodyFile != "" {
+		b, err := readFile(*bodyFile)
+		if err != nil {
+			return fail(err)
+		}
+		text = string(b)
+	}
+	msg, err := buildMessage(f.from, f.to, *subject, *headers, text, *attachments)
+	if err != nil {
+		return fail(err)
+	}
+
+	var transcript io.Writer = os.Stdout
+	if *quiet {
+		transcript = nil
+	}
+	c, err := f.connect(transcript)
+	if err != nil {
+		return fail(err)
+	}
+	if err := c.send(f.from, f.to, msg); err != nil {
+		c.text.Close()
+		return fail(err)
+	}
+	if err := c.quit(); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func bench(args []string) int {
+	var f smtpFlags
+	flags := newFlags("bench [--sessions N] [--messages M] [--size bytes]")
+	f.register(flags, []string{"bench@example.com"})
+	sessions := flags.Int("sessions", 10, "Number of concurrent sessions")
+	messages := flags.Int("messages", 100, "Number of messages sent by each session")
+	size := flags.Int("size", 1024, "Size in bytes of the body of each message")
+	if code := parseFlags(flags, args); code >= 0 {
+		return code
+	}
+
+	msg, err := buildMessage(f.from, f.to, "Benchmark", nil, benchBody(*size), nil)
+	if err != nil {
+		return fail(err)
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	latencies := []time.Duration{}
+	failed := 0
+	errs := map[string]int{}
+	failure := func(err error, count int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		failed += count
+		errs[err.Error()]++
+	}
+
+	start := time.Now()
+	for i := 0; i < *sessions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := f.connect(nil)
+			if err != nil {
+				failure(err, *messages)
+				return
+			}
+			for j := 0; j < *messages; j++ {
+				sent := time.Now()
+				if err := c.send(f.from, f.to, msg); err != nil {
+					failure(err, *messages-j)
+					c.text.Close()
+					return
+				}
+				latency := time.Since(sent)
+				mutex.Lock()
+				latencies = append(latencies, latency)
+				mutex.Unlock()
+			}
+			if err := c.quit(); err != nil {
+				failure(err, 0)
+			}
+		}()
+	}
+	wg.Wait()
+	duration := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Printf("Sessions:    %v\n", *sessions)
+	fmt.Printf("Messages:    %v sent, %v failed\n", len(latencies), failed)
+	fmt.Printf("Duration:    %v\n", duration.Round(time.Millisecond))
+	fmt.Printf("Throughput:  %.1f msg/s\n", float64(len(latencies))/duration.Seconds())
+	if len(latencies) > 0 {
+		fmt.Printf("Latency:     p50 %v  p90 %v  p99 %v  max %v\n", percentile(latencies, 50), percentile(latencies, 90),
+			percentile(latencies, 99), percentile(latencies, 100))
+	}
+	for err, count := range errs {
+		fmt.Printf("Error:       %v (%v times)\n", err, count)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// percentile returns the p-th percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Microsecond)
+}
+
+// benchBody returns a body of approximately size bytes, made of lines of 76 characters.
+func benchBody(size int) string {
+	line := strings.Repeat("0123456789", 8)[:76]
+	var b strings.Builder
+	for b.Len() < size {
+		b.WriteString(line + "\r\n")
+	}
+	return b.String()
+}
+
+// readFile reads the file at path, or stdin if path is -.
+func readFile(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// buildMessage returns a RFC 5322 message with CRLF line endings, headers given as "Name: value" replace default ones.
+// A Content-Type header gives the type of the body, which is the first part of the message if there are attachments.
+func buildMessage(from string, to []string, subject string, headers []string, body string, attachments []string) ([]byte, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	fields := [][2]string{
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"From", from},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Message-ID", fmt.Sprintf("<%v@mailmock>", hex.EncodeToString(id))},
+		{"MIME-Version", "1.0"},
+	}
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected format is \"Name: value\"", header)
+		}
+		name, value := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+		replaced := false
+		for i := range fields {
+			if strings.EqualFold(fields[i][0], name) {
+				fields[i][1], replaced = value, true
+			}
+		}
+		if !replaced {
+			fields = append(fields, [2]string{name, value})
+		}
+	}
+
+	textType := "text/plain; charset=utf-8"
+	for i := 0; i < len(fields); i++ {
+		if fields[i][0] == "Content-Type" {
+			textType = fields[i][1]
+			fields = append(fields[:i], fields[i+1:]...)
+			i--
+		}
+	}
+
+	lines, err := readLines(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	text := strings.Join(lines, "\r\n") + "\r\n"
+
+	var b bytes.Buffer
+	if len(attachments) == 0 {
+		fields = append(fields, [2]string{"Content-Type", textType})
+		writeFields(&b, fields)
+		b.WriteString("\r\n" + text)
+		return b.Bytes(), nil
+	}
+
+	var parts bytes.Buffer
+	mw := multipart.NewWriter(&parts)
+	pw, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {textType}})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(pw, text); err != nil {
+		return nil, err
+	}
+	for _, path := range attachments {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(path))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(path)})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(content)
+		for len(encoded) > 76 {
+			if _, err := io.WriteString(pw, encoded[:76]+"\r\n"); err != nil {
+				return nil, err
+			}
+			encoded = encoded[76:]
+		}
+		if _, err := io.WriteString(pw, encoded+"\r\n"); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	fields = append(fields, [2]string{"Content-Type", "multipart/mixed; boundary=" + mw.Boundary()})
+	writeFields(&b, fields)
+	b.WriteString("\r\n")
+	b.Write(parts.Bytes())
+	return b.Bytes(), nil
+}
+
+func writeFields(b *bytes.Buffer, fields [][2]string) {
+	for _, field := range fields {
+		b.WriteString(field[0] + ": " + field[1] + "\r\n")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/pkg/mailmocktest"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+// selfSigned returns a TLS configuration with a self-signed certificate for 127.0.0.1.
+func selfSigned(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err, "")
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestBuildMessage(t *testing.T) {
+	msg, err := buildMessage("alice@example.com", []string{"bob@example.com", "carol@example.com"}, "Hello",
+		[]string{"X-Test: 1", "subject: Replaced"}, "Line 1\nLine 2\r\n", nil)
+	assert.NoError(t, err, "")
+	assert.NotContains(t, strings.ReplaceAll(string(msg), "\r\n", ""), "\n", "Lines MUST end with CRLF")
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	assert.NoError(t, err, "")
+	assert.Equal(t, "alice@example.com", m.Header.Get("From"), "")
+	assert.Equal(t, "bob@example.com, carol@example.com", m.Header.Get("To"), "")
+	assert.Equal(t, []string{"Replaced"}, m.Header["Subject"], "Headers given MUST replace default ones")
+	assert.Equal(t, "1", m.Header.Get("X-Test"), "")
+	assert.Equal(t, []string{"text/plain; charset=utf-8"}, m.Header["Content-Type"], "")
+	body, _ := io.ReadAll(m.Body)
+	assert.Equal(t, "Line 1\r\nLine 2\r\n", string(body), "")
+
+	_, err = buildMessage("alice@example.com", []string{"bob@example.com"}, "Hello", []string{"Invalid"}, "", nil)
+	assert.Error(t, err, "")
+}
+
+func TestBuildMessageContentType(t *testing.T) {
+	msg, err := buildMessage("alice@example.com", []string{"bob@example.com"}, "Hello",
+		[]string{"Content-Type: text/html; charset=utf-8"}, "<p>Hello</p>", nil)
+	assert.NoError(t, err, "")
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	assert.NoError(t, err, "")
+	assert.Equal(t, []string{"text/html; charset=utf-8"}, m.Header["Content-Type"], "Content-Type MUST NOT be duplicated")
+}
+
+func TestBuildMessageAttachments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.txt")
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Repeat("attached ", 20)), 0o600), "")
+
+	msg, err := buildMessage("alice@example.com", []string{"bob@example.com"}, "Hello",
+		[]string{"Content-Type: text/html"}, "<p>Hello</p>", []string{path})
+	assert.NoError(t, err, "")
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	assert.NoError(t, err, "")
+	assert.Len(t, m.Header["Content-Type"], 1, "")
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	assert.NoError(t, err, "")
+	assert.Equal(t, "multipart/mixed", mediaType, "")
+
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	part, err := mr.NextPart()
+	assert.NoError(t, err, "")
+	assert.Equal(t, "text/html", part.Header.Get("Content-Type"), "Content-Type MUST give the type of the body part")
+	part, err = mr.NextPart()
+	assert.NoError(t, err, "")
+	assert.Equal(t, "report.txt", part.FileName(), "")
+	assert.Equal(t, "base64", part.Header.Get("Content-Transfer-Encoding"), "")
+	content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	assert.NoError(t, err, "")
+	assert.Equal(t, strings.Repeat("attached ", 20), string(content), "")
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err, "")
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{}
+	for i := 1; i <= 10; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 5*time.Millisecond, percentile(sorted, 50), "")
+	assert.Equal(t, 9*time.Millisecond, percentile(sorted, 90), "")
+	assert.Equal(t, 10*time.Millisecond, percentile(sorted, 99), "")
+	assert.Equal(t, 10*time.Millisecond, percentile(sorted, 100), "")
+	assert.Equal(t, time.Millisecond, percentile(sorted, 0), "")
+	assert.Equal(t, 7*time.Millisecond, percentile([]time.Duration{7 * time.Millisecond}, 50), "")
+}
+
+func TestSMTPClient(t *testing.T) {
+	srv := mailmocktest.Start(t)
+
+	var transcript bytes.Buffer
+	f := smtpFlags{server: srv.SMTPAddr, helo: "client.example.com", timeout: 5 * time.Second}
+	c, err := f.connect(&transcript)
+	assert.NoError(t, err, "")
+	msg, err := buildMessage("alice@example.com", []string{"bob@example.com"}, "Hello", nil, ".leading dot", nil)
+	assert.NoError(t, err, "")
+	assert.NoError(t, c.send("alice@example.com", []string{"bob@example.com"}, msg), "")
+	assert.NoError(t, c.quit(), "")
+
+	trs := srv.WaitForMessages(t, 1, 5*time.Second)
+	assert.Equal(t, "client.example.com", trs[0].Client, "")
+	assert.Equal(t, ".leading dot", trs[0].Mail.Content[len(trs[0].Mail.Content)-1], "Lines MUST be dot-stuffed")
+	assert.Contains(t, transcript.String(), "C: EHLO client.example.com\n", "")
+	assert.Contains(t, transcript.String(), "S: 221 ", "")
+}
+
+func TestSMTPClientStartTLS(t *testing.T) {
+	srv := mailmocktest.StartWithOptions(t, smtpd.Options{TLS: selfSigned(t), AuthRequired: true})
+
+	var transcript bytes.Buffer
+	f := smtpFlags{server: srv.SMTPAddr, helo: "localhost", startTLS: true, insecure: true,
+		username: "bob", password: "secret", timeout: 5 * time.Second}
+	c, err := f.connect(&transcript)
+	assert.NoError(t, err, "")
+	_, ok := c.extensions["STARTTLS"]
+	assert.False(t, ok, "Extensions MUST be read again after the TLS negotiation")
+	assert.NoError(t, c.send("bob@example.com", []string{"alice@example.com"}, []byte("Subject: Hello\r\n\r\nHello\r\n")), "")
+	assert.NoError(t, c.quit(), "")
+
+	trs := srv.WaitForMessages(t, 1, 5*time.Second)
+	assert.Len(t, trs, 1, "")
+	assert.Contains(t, transcript.String(), "<TLS negotiated>", "")
+	assert.Contains(t, transcript.String(), "C: AUTH PLAIN ", "")
+}
+
+func TestSMTPClientAuthLogin(t *testing.T) {
+	srv := mailmocktest.StartWithOptions(t, smtpd.Options{
+		Authenticator: func(username, password string) bool { return username == "bob" && password == "secret" },
+	})
+
+	var transcript bytes.Buffer
+	c, err := dialSMTP(srv.SMTPAddr, 5*time.Second, &transcript)
+	assert.NoError(t, err, "")
+	assert.NoError(t, c.hello("localhost"), "")
+	c.extensions["AUTH"] = "LOGIN" // LOGIN is used only if PLAIN is not advertised
+	assert.Error(t, c.auth("bob", "wrong"), "")
+	assert.NoError(t, c.auth("bob", "secret"), "")
+	assert.NoError(t, c.quit(), "")
+	assert.Contains(t, transcript.String(), "C: AUTH LOGIN\n", "")
+	assert.Contains(t, transcript.String(), "S: 235 ", "")
+
+	c, err = dialSMTP(srv.SMTPAddr, 5*time.Second, nil)
+	assert.NoError(t, err, "")
+	assert.NoError(t, c.hello("localhost"), "")
+	assert.Error(t, c.startTLS(&tls.Config{}), "STARTTLS MUST NOT be used if it is not advertised") // #nosec G402
+	assert.NoError(t, c.quit(), "")
+}
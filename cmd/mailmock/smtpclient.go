@@ -0,0 +1,203 @@
+// Mailmock - Lighweight SMTP server for testing
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// smtpClient is a minimal SMTP client, it writes the transcript of the session if transcript is not nil.
+type smtpClient struct {
+	conn       net.Conn
+	text       *textproto.Conn
+	transcript io.Writer
+	extensions map[string]string // extensions advertised in reply to EHLO, with their parameters
+	timeout    time.Duration     // maximum duration of each command
+}
+
+// dialSMTP connects to the SMTP server at addr and reads the greeting.
+func dialSMTP(addr string, timeout time.Duration, transcript io.Writer) (*smtpClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &smtpClient{conn: conn, text: textproto.NewConn(conn), transcript: transcript, timeout: timeout}
+	if _, err := c.reply(220); err != nil {
+		c.text.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// cmd sends a command and reads the reply, an error is returned if the reply code is not the expected one.
+func (c *smtpClient) cmd(expect int, format string, args ...interface{}) (string, error) {
+	line := fmt.Sprintf(format, args...)
+	c.record("C: ", line)
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return "", err
+	}
+	if err := c.text.PrintfLine("%s", line); err != nil {
+		return "", err
+	}
+	return c.reply(expect)
+}
+
+// reply reads a reply, an error is returned if the reply code is not the expected one.
+func (c *smtpClient) reply(expect int) (string, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return "", err
+	}
+	code, msg, err := c.text.ReadResponse(expect)
+	if code == 0 {
+		return "", err // network error
+	}
+	lines := strings.Split(msg, "\n")
+	for i, line := range lines {
+		separator := "-" // continuation of a multiline reply
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		c.record("S: ", fmt.Sprintf("%d%s%s", code, separator, line))
+	}
+	return msg, err
+}
+
+func (c *smtpClient) record(prefix string, lines ...string) {
+	if c.transcript == nil {
+		return
+	}
+	for _, line := range lines {
+		fmt.Fprintln(c.transcript, prefix+line)
+	}
+}
+
+// hello sends EHLO, or HELO if the server doesn't support extensions.
+func (c *smtpClient) hello(name string) error {
+	msg, err := c.cmd(250, "EHLO %s", name)
+	if err != nil {
+		if _, err = c.cmd(250, "HELO %s", name); err != nil {
+			return err
+		}
+		c.extensions = map[string]string{}
+		return nil
+	}
+	c.extensions = map[string]string{}
+	for _, line := range strings.Split(msg, "\n")[1:] {
+		fields := strings.SplitN(line, " ", 2)
+		params := ""
+		if len(fields) > 1 {
+			params = fields[1]
+		}
+		c.extensions[strings.ToUpper(fields[0])] = params
+	}
+	return nil
+}
+
+// startTLS upgrades the connection to TLS, hello must be sent again afterwards.
+func (c *smtpClient) startTLS(config *tls.Config) error {
+	if _, ok := c.extensions["STARTTLS"]; !ok {
+		return fmt.Errorf("server doesn't support STARTTLS")
+	}
+	if _, err := c.cmd(220, "STARTTLS"); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+	c.text = textproto.NewConn(tlsConn)
+	c.record("", "<TLS negotiated>")
+	return nil
+}
+
+// auth authenticates with the PLAIN mechanism, or LOGIN if PLAIN is not advertised.
+func (c *smtpClient) auth(username, password string) error {
+	mechanisms, ok := c.extensions["AUTH"]
+	if !ok {
+		return fmt.Errorf("server doesn't support AUTH")
+	}
+	encode := base64.StdEncoding.EncodeToString
+	if strings.Contains(" "+strings.ToUpper(mechanisms)+" ", " LOGIN ") &&
+		!strings.Contains(" "+strings.ToUpper(mechanisms)+" ", " PLAIN ") {
+		if _, err := c.cmd(334, "AUTH LOGIN"); err != nil {
+			return err
+		}
+		if _, err := c.cmd(334, "%s", encode([]byte(username))); err != nil {
+			return err
+		}
+		_, err := c.cmd(235, "%s", encode([]byte(password)))
+		return err
+	}
+	_, err := c.cmd(235, "AUTH PLAIN %s", encode([]byte("\x00"+username+"\x00"+password)))
+	return err
+}
+
+// send sends a mail in a new transaction, msg must have CRLF line endings.
+func (c *smtpClient) send(from string, to []string, msg []byte) error {
+	if _, err := c.cmd(250, "MAIL FROM:<%s>", from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if _, err := c.cmd(250, "RCPT TO:<%s>", rcpt); err != nil {
+			return err
+		}
+	}
+	if _, err := c.cmd(354, "DATA"); err != nil {
+		return err
+	}
+	if c.transcript != nil {
+		c.record("C: ", strings.Split(strings.TrimSuffix(string(msg), "\r\n"), "\r\n")...)
+		c.record("C: ", ".")
+	}
+	w := c.text.DotWriter()
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	_, err := c.reply(250)
+	return err
+}
+
+// quit ends the session and closes the connection.
+func (c *smtpClient) quit() error {
+	_, err := c.cmd(221, "QUIT")
+	c.text.Close()
+	return err
+}
+
+// readLines reads all lines from r, with any line ending.
+func readLines(r io.Reader) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+	return lines, scanner.Err()
+}
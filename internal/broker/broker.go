@@ -0,0 +1,71 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package broker dispatches live events to the subscribers of Mailmock REST API.
+package broker
+
+import "sync"
+
+// Event types
+const (
+	TypeTransaction = "transaction" // a transaction was stored
+	TypeSession     = "session"     // a session event occurred
+)
+
+// Event is published to every subscriber.
+type Event struct {
+	Type      string      // Type of event (TypeTransaction or TypeSession)
+	Namespace string      // Namespace of the stored transaction (transaction events only)
+	ID        int         // ID of the stored transaction (transaction events only)
+	Data      interface{} // Object related to the event
+}
+
+// bufferSize is the number of events a subscriber can lag behind before missing events.
+const bufferSize = 64
+
+var (
+	mutex       sync.RWMutex
+	subscribers = map[chan Event]struct{}{}
+)
+
+// Publish sends the event to all subscribers, slow subscribers will miss the event.
+func Publish(e Event) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for c := range subscribers {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving all published events, and a function to cancel the subscription.
+func Subscribe() (<-chan Event, func()) {
+	c := make(chan Event, bufferSize)
+	mutex.Lock()
+	subscribers[c] = struct{}{}
+	mutex.Unlock()
+	return c, func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if _, ok := subscribers[c]; ok {
+			delete(subscribers, c)
+			close(c)
+		}
+	}
+}
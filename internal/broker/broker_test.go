@@ -0,0 +1,32 @@
+package broker_test
+
+import (
+	"testing"
+
+	"github.com/adrienaury/mailmock/internal/broker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerNominal(t *testing.T) {
+	events, cancel := broker.Subscribe()
+
+	broker.Publish(broker.Event{Type: broker.TypeTransaction, ID: 1, Data: "test"})
+	assert.Equal(t, broker.Event{Type: broker.TypeTransaction, ID: 1, Data: "test"}, <-events, "")
+
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok, "")
+
+	cancel()
+	broker.Publish(broker.Event{Type: broker.TypeTransaction, ID: 2, Data: "test"})
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	events, cancel := broker.Subscribe()
+	defer cancel()
+
+	for i := 0; i < 100; i++ {
+		broker.Publish(broker.Event{Type: broker.TypeSession, ID: i})
+	}
+	assert.Len(t, events, 64, "")
+}
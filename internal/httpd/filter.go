@@ -0,0 +1,72 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+)
+
+// filter selects transactions with query parameters.
+type filter struct {
+	sender    string                 // part of the sender address
+	recipient string                 // part of one of the recipients address
+	state     smtpd.TransactionState // state of the transaction
+}
+
+func parseFilter(r *http.Request) filter {
+	query := r.URL.Query()
+	return filter{
+		sender:    strings.ToLower(query.Get("sender")),
+		recipient: strings.ToLower(query.Get("recipient")),
+		state:     smtpd.TransactionState(query.Get("state")),
+	}
+}
+
+// empty returns true if the filter selects everything.
+func (f filter) empty() bool {
+	return f == filter{}
+}
+
+// match returns true if the object is a transaction selected by the filter.
+func (f filter) match(obj interface{}) bool {
+	if f.empty() {
+		return true
+	}
+	tr, ok := obj.(*smtpd.Transaction)
+	if !ok {
+		return false
+	}
+	if f.state != "" && f.state != tr.State {
+		return false
+	}
+	if f.sender != "" && !strings.Contains(strings.ToLower(tr.Mail.Envelope.Sender), f.sender) {
+		return false
+	}
+	if f.recipient != "" {
+		for _, rcpt := range tr.Mail.Envelope.Recipients {
+			if strings.Contains(strings.ToLower(rcpt), f.recipient) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/go-chi/render"
+)
+
+// Health statuses
+const (
+	healthOK          = "ok"
+	healthUnavailable = "unavailable"
+)
+
+// repositoryHealth gives the state of the repository.
+type repositoryHealth struct {
+	Status     string `json:"status"`
+	Namespaces int    `json:"namespaces"` // number of namespaces, excluding the default namespace
+	Count      int    `json:"count"`      // number of transactions stored in all namespaces
+	Bytes      int    `json:"bytes"`      // total size of transactions stored in all namespaces
+}
+
+// health is the response of health endpoints.
+type health struct {
+	Status     string               `json:"status"`
+	Listeners  []smtpd.ServerStatus `json:"listeners"`
+	Repository repositoryHealth     `json:"repository"`
+}
+
+// probe is a check of the repository in progress, shared by concurrent health requests.
+type probe struct {
+	done   chan struct{} // closed once the check is done
+	result repositoryHealth
+}
+
+// readRepository reads statistics of all namespaces.
+func readRepository() repositoryHealth {
+	names := repository.Namespaces()
+	h := repositoryHealth{Status: healthOK, Namespaces: len(names)}
+	for _, name := range append(names, "") {
+		if repo := repository.Find(name); repo != nil {
+			stats := repo.Stats()
+			h.Count += stats.Count
+			h.Bytes += stats.Bytes
+		}
+	}
+	return h
+}
+
+// checkRepository reads statistics of all namespaces, the repository is unavailable if it takes more than a second.
+// A check still in progress, stalled by a lock of the repository, is awaited again instead of starting another one.
+func (srv *Server) checkRepository() repositoryHealth {
+	srv.probeMutex.Lock()
+	p := srv.probe
+	if p == nil {
+		p = &probe{done: make(chan struct{})}
+		srv.probe = p
+		go func() {
+			p.result = readRepository()
+			srv.probeMutex.Lock()
+			srv.probe = nil
+			srv.probeMutex.Unlock()
+			close(p.done)
+		}()
+	}
+	srv.probeMutex.Unlock()
+	select {
+	case <-p.done:
+		return p.result
+	case <-time.After(time.Second):
+		return repositoryHealth{Status: healthUnavailable}
+	}
+}
+
+// check returns the health of the server, ready is true if every SMTP server is accepting connections.
+func (srv *Server) check() (h health, ready bool) {
+	srv.mutex.RLock()
+	ready = !srv.stopping
+	h.Listeners = make([]smtpd.ServerStatus, 0, len(srv.listeners))
+	for _, listener := range srv.listeners {
+		status := listener.Status()
+		ready = ready && status.State == smtpd.SVListening
+		h.Listeners = append(h.Listeners, status)
+	}
+	srv.mutex.RUnlock()
+
+	h.Repository = srv.checkRepository()
+	h.Status = healthOK
+	if h.Repository.Status != healthOK {
+		h.Status = healthUnavailable
+	}
+	return h, ready && h.Status == healthOK
+}
+
+// healthz succeeds if the process is able to serve requests and the repository is available.
+func (srv *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	h, _ := srv.check()
+	if h.Status != healthOK {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, h)
+}
+
+// readyz succeeds if every SMTP server is accepting connections and the server is not shutting down.
+func (srv *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	h, ready := srv.check()
+	if !ready {
+		h.Status = "not ready"
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, h)
+}
@@ -0,0 +1,115 @@
+package httpd_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/httpd"
+	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+type health struct {
+	Status    string               `json:"status"`
+	Listeners []smtpd.ServerStatus `json:"listeners"`
+}
+
+// get requests the path and decodes the health response.
+func get(t *testing.T, url string) (int, health) {
+	res, err := http.Get(url) // #nosec G107
+	assert.NoError(t, err, "")
+	defer res.Body.Close()
+	var h health
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&h), "")
+	return res.StatusCode, h
+}
+
+// serve starts a SMTP server and the HTTP server reporting its status, they stop when stop is closed.
+func serve(t *testing.T, stop chan struct{}) (smtpAddr string, url string) {
+	smtpLn, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "")
+	httpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "")
+
+	smtpsrv := smtpd.NewServer("health", "127.0.0.1", "0", nil, log.LoggerNoop{})
+	httpsrv := httpd.NewServer("health", "127.0.0.1", "0", log.LoggerNoop{})
+	httpsrv.AddListener(smtpsrv)
+	go func() { _ = smtpsrv.Serve(smtpLn, stop) }()
+	go func() { _ = httpsrv.Serve(httpLn, stop) }()
+
+	url = "http://" + httpLn.Addr().String()
+	assert.Eventually(t, func() bool {
+		code, _ := get(t, url+"/readyz")
+		return code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond, "Server MUST be ready once listening")
+	return smtpLn.Addr().String(), url
+}
+
+func TestHealth(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	_, url := serve(t, stop)
+
+	code, h := get(t, url+"/healthz")
+	assert.Equal(t, http.StatusOK, code, "")
+	assert.Equal(t, "ok", h.Status, "")
+	assert.Len(t, h.Listeners, 1, "")
+	assert.Equal(t, "health", h.Listeners[0].Name, "")
+	assert.Equal(t, smtpd.SVListening, h.Listeners[0].State, "")
+
+	code, h = get(t, url+"/readyz")
+	assert.Equal(t, http.StatusOK, code, "")
+	assert.Equal(t, "ok", h.Status, "")
+}
+
+func TestHealthDrain(t *testing.T) {
+	stop := make(chan struct{})
+	smtpAddr, url := serve(t, stop)
+
+	c, err := smtp.Dial(smtpAddr)
+	assert.NoError(t, err, "")
+	assert.NoError(t, c.Hello("localhost"), "")
+
+	close(stop)
+	assert.Eventually(t, func() bool {
+		code, h := get(t, url+"/readyz")
+		return code == http.StatusServiceUnavailable && h.Status == "not ready" && h.Listeners[0].State == smtpd.SVDraining
+	}, 5*time.Second, 10*time.Millisecond, "Server MUST NOT be ready while sessions are drained")
+
+	code, h := get(t, url+"/healthz")
+	assert.Equal(t, http.StatusOK, code, "Server MUST stay healthy while sessions are drained")
+	assert.Equal(t, "ok", h.Status, "")
+
+	assert.Error(t, c.Noop(), "Sessions MUST be closed once the server is stopping")
+	c.Close()
+	assert.Eventually(t, func() bool {
+		res, err := http.Get(url + "/healthz") // #nosec G107
+		if err != nil {
+			return true
+		}
+		res.Body.Close()
+		return false
+	}, 5*time.Second, 10*time.Millisecond, "HTTP server MUST stop once sessions are drained")
+}
+
+func TestHealthConcurrent(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	_, url := serve(t, stop)
+
+	codes := make(chan int)
+	for i := 0; i < 20; i++ {
+		go func() {
+			code, _ := get(t, url+"/readyz")
+			codes <- code
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, http.StatusOK, <-codes, "Concurrent checks MUST share the repository probe")
+	}
+}
@@ -22,6 +22,9 @@ import (
 	"time"
 
 	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/internal/metrics"
+	"github.com/adrienaury/mailmock/internal/tracing"
+	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
 
@@ -37,6 +40,7 @@ func (c chilogger) middleware(next http.Handler) http.Handler {
 			requestID = reqID.(string)
 		}
 
+		r, end := tracing.Request(r)
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 		next.ServeHTTP(ww, r)
 
@@ -47,6 +51,15 @@ func (c chilogger) middleware(next http.Handler) http.Handler {
 		method := r.Method
 		bytes := ww.BytesWritten()
 
+		route := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+		if route != "" {
+			metrics.Request(method, route, status, latency)
+		}
+		end(route, status)
+
 		fields := log.Fields{"took": latency, "status": status, "remote": remote, "request": request, "method": method, "bytes": bytes}
 		if requestID != "" {
 			fields["request-id"] = requestID
@@ -0,0 +1,74 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// releaseRequest is the body of a release request.
+type releaseRequest struct {
+	To []string `json:"to"` // recipients, those of the transaction if empty
+}
+
+func (srv *Server) releaseRoutes(router chi.Router) {
+	router.Post("/{ID}/release", srv.release)
+}
+
+// release forwards the mail of a transaction with the relay, the outcome is recorded in the transaction and returned.
+func (srv *Server) release(w http.ResponseWriter, r *http.Request) {
+	if srv.relay == nil {
+		http.Error(w, "No relay configured", http.StatusNotImplemented)
+		return
+	}
+	i, err := strconv.ParseInt(chi.URLParam(r, "ID"), 10, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tr, ok := transactions(r).Use(int(i)).(*smtpd.Transaction)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	req := releaseRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(req.To) == 0 {
+		req.To = tr.Mail.Envelope.Recipients
+	}
+	outcome, err := srv.relay.Release(tr, req.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if outcome.Error != "" {
+		render.Status(r, http.StatusBadGateway)
+	}
+	render.JSON(w, r, outcome)
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// listener returns the SMTP server with the name given in the URL, or nil.
+func (srv *Server) listener(r *http.Request) *smtpd.Server {
+	srv.mutex.RLock()
+	defer srv.mutex.RUnlock()
+	for _, listener := range srv.listeners {
+		if listener.Status().Name == chi.URLParam(r, "name") {
+			return listener
+		}
+	}
+	return nil
+}
+
+// getReplies returns the replies of a SMTP server.
+func (srv *Server) getReplies(w http.ResponseWriter, r *http.Request) {
+	listener := srv.listener(r)
+	if listener == nil {
+		http.NotFound(w, r)
+		return
+	}
+	render.JSON(w, r, listener.Replies())
+}
+
+// putReplies replaces the replies of a SMTP server, sessions in progress keep the previous replies.
+func (srv *Server) putReplies(w http.ResponseWriter, r *http.Request) {
+	listener := srv.listener(r)
+	if listener == nil {
+		http.NotFound(w, r)
+		return
+	}
+	replies := smtpd.Replies{}
+	if err := json.NewDecoder(r.Body).Decode(&replies); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for resp, response := range replies.Responses {
+		if response.Code < 200 || response.Code > 599 || len(response.Msg) == 0 {
+			http.Error(w, fmt.Sprintf("invalid reply %v, code must be between 200 and 599 and message must not be empty", resp), http.StatusBadRequest)
+			return
+		}
+	}
+	listener.SetReplies(replies)
+	srv.logger.Info("Replies of SMTP server changed", log.Fields{log.FieldListener: listener.Status().Name})
+	render.JSON(w, r, listener.Replies())
+}
@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/adrienaury/mailmock/internal/metrics"
 	"github.com/adrienaury/mailmock/internal/repository"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -35,33 +36,84 @@ func (srv *Server) Routes() *chi.Mux {
 		render.SetContentType(render.ContentTypeJSON), // Set content-Type headers as application/json
 		middleware.RequestID,                          // Creates a unique request ID
 		chilogger{srv.logger}.middleware,              // Log API request calls
-		middleware.DefaultCompress,                    // Compress results, mostly gzipping assets and json
 		middleware.RedirectSlashes,                    // Redirect slashes to no slash URL versions
 		middleware.Recoverer,                          // Recover from panics without crashing server
 	)
 
+	router.Handle("/metrics", metrics.Handler())
+	router.Get("/healthz", srv.healthz)
+	router.Get("/readyz", srv.readyz)
 	router.Route("/v1", func(r chi.Router) {
-		r.Mount("/api/mailmock", myRoutes())
+		r.Mount("/api/mailmock", transactions.routes(srv.releaseRoutes))
+		r.Mount("/api/sessions", sessions.routes())
+		r.Mount("/api/webhooks/deliveries", deliveries.routes())
+		r.Get("/api/events", srv.stream)
+		r.Get("/api/stats", transactions.getStats)
+		r.Get("/api/listeners/{name}/replies", srv.getReplies)
+		r.Put("/api/listeners/{name}/replies", srv.putReplies)
+		r.With(middleware.DefaultCompress).Get("/namespaces", getNamespaces)
+		r.Route("/namespaces/{ns}", func(r chi.Router) {
+			r.Mount("/mailmock", transactions.routes(srv.releaseRoutes))
+			r.Get("/events", srv.stream)
+			r.Get("/stats", transactions.getStats)
+		})
 	})
 
 	return router
 }
 
-func myRoutes() *chi.Mux {
+// collection returns the repository holding the objects served by a request.
+type collection func(r *http.Request) *repository.Repository
+
+// transactions returns the repository of the namespace given in the URL, or the default repository.
+// An empty repository is returned if the namespace doesn't exist yet.
+var transactions collection = func(r *http.Request) *repository.Repository {
+	if repo := repository.Find(chi.URLParam(r, "ns")); repo != nil {
+		return repo
+	}
+	return repository.New()
+}
+
+// sessions returns the repository of session records.
+var sessions collection = func(*http.Request) *repository.Repository {
+	return repository.Sessions()
+}
+
+// deliveries returns the repository of webhook deliveries.
+var deliveries collection = func(*http.Request) *repository.Repository {
+	return repository.Deliveries()
+}
+
+// routes returns the routes of the collection, extra adds routes specific to the collection.
+func (repo collection) routes(extra ...func(chi.Router)) *chi.Mux {
 	router := chi.NewRouter()
-	router.Get("/{ID}", getOne)
-	router.Get("/", getAll)
+	router.Use(middleware.DefaultCompress) // Compress results, mostly gzipping assets and json
+	router.Get("/{ID}", repo.getOne)
+	router.Get("/", repo.getAll)
+	router.Delete("/{ID}", repo.deleteOne)
+	router.Delete("/", repo.deleteAll)
+	for _, add := range extra {
+		add(router)
+	}
 	return router
 }
 
-func getOne(w http.ResponseWriter, r *http.Request) {
+func getNamespaces(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, repository.Namespaces())
+}
+
+func (repo collection) getStats(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, repo(r).Stats())
+}
+
+func (repo collection) getOne(w http.ResponseWriter, r *http.Request) {
 	trID := chi.URLParam(r, "ID")
 	i, err := strconv.ParseInt(trID, 10, 0)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	obj := repository.Use(int(i))
+	obj := repo(r).Use(int(i))
 	if obj == nil {
 		http.NotFound(w, r)
 		return
@@ -71,7 +123,7 @@ func getOne(w http.ResponseWriter, r *http.Request) {
 
 const maxLimit = 50
 
-func getAll(w http.ResponseWriter, r *http.Request) {
+func (repo collection) getAll(w http.ResponseWriter, r *http.Request) {
 	var from, limit int64
 	var err error
 
@@ -100,17 +152,43 @@ func getAll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	objs, all := repository.All(int(from), int(limit))
-	if objs == nil {
+	if int(from) > repo(r).Len() {
 		http.NotFound(w, r)
 		return
 	}
 
-	if !all {
-		render.Status(r, http.StatusPartialContent)
+	objs, next, total := repo(r).Filter(int(from), int(limit), parseFilter(r).match)
+	if next < repo(r).Len() {
+		render.Status(r, http.StatusPartialContent) // more objects follow the page
 	}
-	w.Header().Set("Content-Range", fmt.Sprintf("%v-%v/%v", from, from+limit, repository.Len()))
+	w.Header().Set("Content-Range", fmt.Sprintf("%v-%v/%v", from, next, total))
 	w.Header().Set("Accept-Range", fmt.Sprintf("%v %v", "mailmock", maxLimit))
 
 	render.JSON(w, r, objs) // A chi router helper for serializing and returning json
 }
+
+func (repo collection) deleteOne(w http.ResponseWriter, r *http.Request) {
+	trID := chi.URLParam(r, "ID")
+	i, err := strconv.ParseInt(trID, 10, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !repo(r).Delete(int(i)) {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteAll removes the transactions selected by the filter, or resets the repository if there is no filter.
+func (repo collection) deleteAll(w http.ResponseWriter, r *http.Request) {
+	f := parseFilter(r)
+	if f.empty() {
+		repo(r).Reset()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	ids := repo(r).DeleteFunc(f.match)
+	render.JSON(w, r, map[string][]int{"deleted": ids})
+}
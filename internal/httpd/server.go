@@ -22,9 +22,12 @@ import (
 	"context"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/internal/relay"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
 )
 
 // Server is holding the HTTP server properties.
@@ -33,6 +36,17 @@ type Server struct {
 	host   string
 	port   string
 	logger log.Logger
+	done   chan struct{}
+	relay  *relay.Relay // releases transactions on demand, nil if not set
+
+	keepAlive time.Duration // interval between two comments sent on event streams
+
+	mutex     sync.RWMutex
+	listeners []*smtpd.Server // SMTP servers reported by health endpoints
+	stopping  bool
+
+	probeMutex sync.Mutex
+	probe      *probe // check of the repository in progress, nil if none
 }
 
 // NewServer creates a HTTP server.
@@ -44,24 +58,56 @@ func NewServer(name string, host string, port string, logger log.Logger) *Server
 		log.FieldServer: name,
 		log.FieldListen: net.JoinHostPort(host, port),
 	})
-	return &Server{name, host, port, l}
+	return &Server{name: name, host: host, port: port, logger: l, done: make(chan struct{}), keepAlive: defaultKeepAlive}
+}
+
+// AddListener registers a SMTP server, its status is reported by health endpoints.
+// On shutdown, the HTTP server is stopped once every registered SMTP server is stopped.
+func (srv *Server) AddListener(listener *smtpd.Server) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.listeners = append(srv.listeners, listener)
+}
+
+// SetRelay sets the relay used to release transactions on demand.
+func (srv *Server) SetRelay(r *relay.Relay) {
+	srv.relay = r
+}
+
+// SetKeepAlive sets the interval between two comments sent to keep event streams open.
+func (srv *Server) SetKeepAlive(interval time.Duration) {
+	srv.keepAlive = interval
 }
 
 // ListenAndServe starts listening for clients connection and serves requests.
 func (srv *Server) ListenAndServe(stop <-chan struct{}) error {
+	ln, err := net.Listen("tcp", net.JoinHostPort(srv.host, srv.port))
+	if err != nil {
+		srv.logger.Error("HTTP Server failed", log.Fields{log.FieldError: err})
+		return err
+	}
+	return srv.Serve(ln, stop)
+}
+
+// Serve accepts clients connection on the listener and serves requests, until stop is closed.
+func (srv *Server) Serve(ln net.Listener, stop <-chan struct{}) error {
 	router := srv.Routes()
 
 	s := http.Server{
-		Addr:    net.JoinHostPort(srv.host, srv.port),
 		Handler: router,
 
 		ReadTimeout:       5 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		ReadHeaderTimeout: 20 * time.Second,
 	}
+	s.RegisterOnShutdown(func() { close(srv.done) }) // terminates event streams
 
 	go func() {
 		<-stop // wait for stop signal
+		srv.mutex.Lock()
+		srv.stopping = true
+		srv.mutex.Unlock()
+		srv.waitListeners()
 		if err := s.Shutdown(context.Background()); err != nil {
 			srv.logger.Warn("Failed to shutdown HTTP server", log.Fields{log.FieldError: err})
 			if err = s.Close(); err != nil {
@@ -71,10 +117,23 @@ func (srv *Server) ListenAndServe(stop <-chan struct{}) error {
 	}()
 
 	srv.logger.Info("HTTP Server is listening")
-	if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if err := s.Serve(ln); err != nil && err != http.ErrServerClosed {
 		srv.logger.Error("HTTP Server failed", log.Fields{log.FieldError: err})
 		return err
 	}
 	srv.logger.Info("HTTP Server is stopped")
 	return nil
 }
+
+// waitListeners waits for registered SMTP servers to drain their sessions and stop,
+// so the REST API is still available during the drain.
+func (srv *Server) waitListeners() {
+	srv.mutex.RLock()
+	listeners := srv.listeners
+	srv.mutex.RUnlock()
+	for _, listener := range listeners {
+		for state := listener.Status().State; state == smtpd.SVListening || state == smtpd.SVDraining; state = listener.Status().State {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
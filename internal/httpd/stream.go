@@ -0,0 +1,116 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/broker"
+	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/go-chi/chi"
+)
+
+// defaultKeepAlive is the default interval between two comments sent to keep the stream open.
+const defaultKeepAlive = 15 * time.Second
+
+// stream pushes events to the client as Server-Sent Events until the client disconnects.
+// If the URL designates a namespace, only transactions stored in this namespace are pushed.
+func (srv *Server) stream(w http.ResponseWriter, r *http.Request) {
+	ns := chi.URLParam(r, "ns")
+	f := parseFilter(r)
+	types := map[string]bool{}
+	for _, t := range strings.Split(r.URL.Query().Get("type"), ",") {
+		if t != "" {
+			types[t] = true
+		}
+	}
+
+	// the stream must outlive the write timeout of the server
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events, cancel := broker.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(srv.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-srv.done:
+			return
+		case <-ticker.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if len(types) > 0 && !types[e.Type] {
+				continue
+			}
+			if ns != "" && (e.Type != broker.TypeTransaction || e.Namespace != ns) {
+				continue
+			}
+			if e.Type == broker.TypeTransaction && !f.match(e.Data) {
+				continue
+			}
+			err = writeEvent(w, e)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			srv.logger.Debug("Event stream interrupted", log.Fields{log.FieldError: err})
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e broker.Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	switch {
+	case e.Type == broker.TypeTransaction && e.Namespace != "":
+		_, err = fmt.Fprintf(w, "event: %s\nid: %s/%d\ndata: %s\n\n", e.Type, e.Namespace, e.ID, data)
+	case e.Type == broker.TypeTransaction:
+		_, err = fmt.Fprintf(w, "event: %s\nid: %d\ndata: %s\n\n", e.Type, e.ID, data)
+	default:
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package httpd_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/broker"
+	"github.com/adrienaury/mailmock/internal/httpd"
+	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+// subscribe opens the event stream at the path, it returns a function reading the next frame.
+func subscribe(t *testing.T, keepAlive time.Duration, path string) func() []string {
+	srv := httpd.NewServer("stream", "127.0.0.1", "0", log.LoggerNoop{})
+	srv.SetKeepAlive(keepAlive)
+	ts := httptest.NewServer(srv.Routes())
+	t.Cleanup(ts.Close)
+
+	res, err := http.Get(ts.URL + path) // #nosec G107
+	assert.NoError(t, err, "")
+	t.Cleanup(func() { res.Body.Close() })
+	assert.Equal(t, http.StatusOK, res.StatusCode, "")
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"), "")
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	return func() []string {
+		frame := []string{}
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok || line == "" {
+					return frame
+				}
+				frame = append(frame, line)
+			case <-time.After(5 * time.Second):
+				assert.Fail(t, "No event received")
+				return frame
+			}
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	next := subscribe(t, time.Hour, "/v1/api/events?type=transaction")
+
+	tr := smtpd.NewTransaction()
+	tr.Mail.Envelope.Sender = "<alice@example.com>"
+	id := repository.Store(tr)
+	broker.Publish(broker.Event{Type: broker.TypeSession, Data: map[string]interface{}{"event": smtpd.SEClosed}})
+	broker.Publish(broker.Event{Type: broker.TypeTransaction, ID: id, Data: tr})
+
+	frame := next()
+	assert.Len(t, frame, 3, "Events of other types MUST NOT be pushed")
+	assert.Equal(t, "event: transaction", frame[0], "")
+	assert.Equal(t, "id: "+strconv.Itoa(id), frame[1], "")
+	assert.True(t, strings.HasPrefix(frame[2], "data: {"), "")
+	assert.Contains(t, frame[2], "alice@example.com", "")
+}
+
+func TestStreamNamespace(t *testing.T) {
+	next := subscribe(t, time.Hour, "/v1/namespaces/stream/events")
+
+	tr := smtpd.NewTransaction()
+	broker.Publish(broker.Event{Type: broker.TypeTransaction, Namespace: "other", ID: 1, Data: tr})
+	broker.Publish(broker.Event{Type: broker.TypeTransaction, Namespace: "stream", ID: 2, Data: tr})
+
+	frame := next()
+	assert.Equal(t, []string{"event: transaction", "id: stream/2"}, frame[:2], "Events of other namespaces MUST NOT be pushed")
+}
+
+func TestStreamKeepAlive(t *testing.T) {
+	next := subscribe(t, 10*time.Millisecond, "/v1/api/events")
+
+	assert.Equal(t, []string{": keep-alive"}, next(), "")
+}
@@ -33,6 +33,8 @@ const (
 	FieldSession  = "session"  // Current session.
 	FieldCommand  = "command"  // Current command being processed.
 	FieldResponse = "response" // Current response (to be) emitted.
+	FieldRelay    = "relay"    // Address of the downstream server.
+	FieldListener = "listener" // Name of the SMTP server configured by a request.
 )
 
 // Fields is used to define the content of an event with structured fields.
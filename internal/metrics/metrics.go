@@ -0,0 +1,181 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package metrics exposes metrics of Mailmock in Prometheus format.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	sessionsOpened = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "mailmock", Subsystem: "smtp", Name: "sessions_opened_total",
+		Help: "Number of SMTP sessions opened.",
+	})
+	sessionsClosed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mailmock", Subsystem: "smtp", Name: "sessions_closed_total",
+		Help: "Number of SMTP sessions closed, by reason.",
+	}, []string{"reason"})
+	commandsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mailmock", Subsystem: "smtp", Name: "commands_total",
+		Help: "Number of SMTP commands processed, by command and reply code.",
+	}, []string{"command", "code"})
+	transactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mailmock", Subsystem: "smtp", Name: "transactions_total",
+		Help: "Number of SMTP transactions, by final state.",
+	}, []string{"state"})
+	messageSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "mailmock", Subsystem: "smtp", Name: "message_size_bytes",
+		Help:    "Size of the content of completed transactions.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 8), // 1KiB to 16MiB
+	})
+	dataDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "mailmock", Subsystem: "smtp", Name: "data_duration_seconds",
+		Help:    "Duration of the DATA command, including the reception of the content.",
+		Buckets: prometheus.DefBuckets,
+	})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "mailmock", Subsystem: "http", Name: "request_duration_seconds",
+		Help:    "Duration of the requests to the REST API, by method, route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		sessionsOpened, sessionsClosed, commandsProcessed, transactions, messageSize, dataDuration, httpDuration,
+		repositoryCollector{},
+	)
+}
+
+// Handler returns the handler serving metrics in Prometheus format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// SessionEvent counts opened and closed sessions.
+func SessionEvent(s *smtpd.Session, e smtpd.SessionEvent) {
+	switch e {
+	case smtpd.SEConnected:
+		sessionsOpened.Inc()
+	case smtpd.SEClosed:
+		sessionsClosed.WithLabelValues(s.CloseReason).Inc()
+	}
+}
+
+// Command counts processed commands and observes the duration of DATA commands.
+// Commands unknown by the server are counted as UNKNOWN, so that clients can't create labels.
+func Command(s *smtpd.Session, name string, res *smtpd.Response, duration time.Duration) {
+	if !smtpd.IsCommand(name) {
+		name = "UNKNOWN"
+	}
+	commandsProcessed.WithLabelValues(name, strconv.Itoa(int(res.Code))).Inc()
+	if name == "DATA" && res.Code == smtpd.CodeSuccess {
+		dataDuration.Observe(duration.Seconds())
+	}
+}
+
+// Transaction counts transactions and observes the size of completed ones.
+func Transaction(tr *smtpd.Transaction) {
+	transactions.WithLabelValues(string(tr.State)).Inc()
+	if tr.State == smtpd.TSCompleted {
+		messageSize.Observe(float64(tr.Size()))
+	}
+}
+
+// Request observes the duration of a request to the REST API.
+func Request(method, route string, code int, duration time.Duration) {
+	httpDuration.WithLabelValues(method, route, strconv.Itoa(code)).Observe(duration.Seconds())
+}
+
+var (
+	repositoryCount = prometheus.NewDesc("mailmock_repository_objects",
+		"Number of objects stored, by namespace.", []string{"namespace"}, nil)
+	repositoryBytes = prometheus.NewDesc("mailmock_repository_bytes",
+		"Total size of objects stored, by namespace.", []string{"namespace"}, nil)
+	repositoryDeleted = prometheus.NewDesc("mailmock_repository_deleted_total",
+		"Number of objects deleted, by namespace.", []string{"namespace"}, nil)
+	repositoryEvicted = prometheus.NewDesc("mailmock_repository_evicted_total",
+		"Number of objects evicted by the retention policy, by namespace.", []string{"namespace"}, nil)
+)
+
+// namespaceOther is the label of the namespaces counted together beyond the limit.
+const namespaceOther = "other"
+
+// namespaceLimit is the maximum number of namespaces with their own label, namespaces are created by clients.
+var namespaceLimit = 20
+
+// SetNamespaceLimit sets the maximum number of namespaces with their own label in repository metrics,
+// statistics of the other namespaces are summed under the "other" label.
+func SetNamespaceLimit(limit int) {
+	namespaceLimit = limit
+}
+
+// repositoryCollector collects statistics of the repositories at each scrape.
+type repositoryCollector struct{}
+
+func (repositoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- repositoryCount
+	ch <- repositoryBytes
+	ch <- repositoryDeleted
+	ch <- repositoryEvicted
+}
+
+func (repositoryCollector) Collect(ch chan<- prometheus.Metric) {
+	collect := func(name string, stats repository.Stats) {
+		ch <- prometheus.MustNewConstMetric(repositoryCount, prometheus.GaugeValue, float64(stats.Count), name)
+		ch <- prometheus.MustNewConstMetric(repositoryBytes, prometheus.GaugeValue, float64(stats.Bytes), name)
+		ch <- prometheus.MustNewConstMetric(repositoryDeleted, prometheus.CounterValue, float64(stats.Deleted), name)
+		ch <- prometheus.MustNewConstMetric(repositoryEvicted, prometheus.CounterValue, float64(stats.Evicted), name)
+	}
+	collect("", repository.Namespace("").Stats())
+	labelled, others := 0, []repository.Stats{}
+	for _, name := range repository.Namespaces() {
+		repo := repository.Find(name)
+		switch {
+		case repo == nil:
+		case name != namespaceOther && labelled < namespaceLimit:
+			collect(name, repo.Stats())
+			labelled++
+		default:
+			others = append(others, repo.Stats())
+		}
+	}
+	if len(others) > 0 {
+		sum := repository.Stats{}
+		for _, stats := range others {
+			sum.Count += stats.Count
+			sum.Bytes += stats.Bytes
+			sum.Deleted += stats.Deleted
+			sum.Evicted += stats.Evicted
+		}
+		collect(namespaceOther, sum)
+	}
+}
@@ -0,0 +1,70 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/metrics"
+	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(t *testing.T) string {
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	repository.Reset()
+	tr := smtpd.NewTransaction()
+	tr.State = smtpd.TSCompleted
+	tr.Mail.Content = []string{"Subject: test", "", "body"}
+	repository.Store(tr)
+
+	metrics.SessionEvent(&smtpd.Session{}, smtpd.SEConnected)
+	metrics.SessionEvent(&smtpd.Session{CloseReason: smtpd.CRTimeout}, smtpd.SEClosed)
+	metrics.Command(nil, "DATA", &smtpd.Response{Code: smtpd.CodeSuccess}, time.Second)
+	metrics.Command(nil, "FOO", &smtpd.Response{Code: 500}, time.Millisecond)
+	for _, name := range []string{"LHLO", "STARTTLS", "AUTH", "XCLIENT", "XFORWARD"} {
+		metrics.Command(nil, name, &smtpd.Response{Code: smtpd.CodeSuccess}, time.Millisecond)
+	}
+	metrics.Transaction(tr)
+	metrics.Request("GET", "/v1/api/mailmock/", 200, time.Millisecond)
+
+	body := scrape(t)
+	assert.Contains(t, body, "mailmock_smtp_sessions_opened_total 1\n", "")
+	assert.Contains(t, body, `mailmock_smtp_sessions_closed_total{reason="timeout"} 1`, "")
+	assert.Contains(t, body, `mailmock_smtp_commands_total{code="250",command="DATA"} 1`, "")
+	assert.Contains(t, body, `mailmock_smtp_commands_total{code="500",command="UNKNOWN"} 1`, "Unknown commands MUST NOT be used as label")
+	for _, name := range []string{"LHLO", "STARTTLS", "AUTH", "XCLIENT", "XFORWARD"} {
+		assert.Contains(t, body, `mailmock_smtp_commands_total{code="250",command="`+name+`"} 1`, "Commands of extensions MUST be used as label")
+	}
+	assert.Contains(t, body, "mailmock_smtp_data_duration_seconds_sum 1\n", "")
+	assert.Contains(t, body, `mailmock_smtp_transactions_total{state="completed"} 1`, "")
+	assert.Contains(t, body, "mailmock_smtp_message_size_bytes_sum 23\n", "")
+	assert.Contains(t, body, `mailmock_http_request_duration_seconds_count{code="200",method="GET",route="/v1/api/mailmock/"} 1`, "")
+	assert.Contains(t, body, `mailmock_repository_objects{namespace=""} 1`, "")
+	assert.Contains(t, body, `mailmock_repository_bytes{namespace=""} 23`, "")
+}
+
+func TestMetricsNamespaceLimit(t *testing.T) {
+	metrics.SetNamespaceLimit(2)
+	t.Cleanup(func() { metrics.SetNamespaceLimit(20) })
+	for _, name := range []string{"metrics-a", "metrics-b", "metrics-c", "metrics-d"} {
+		name := name
+		repository.Namespace(name).Store("object")
+		t.Cleanup(func() { repository.Remove(name) })
+	}
+
+	body := scrape(t)
+	assert.Contains(t, body, `mailmock_repository_objects{namespace="metrics-a"} 1`, "")
+	assert.Contains(t, body, `mailmock_repository_objects{namespace="metrics-b"} 1`, "")
+	assert.NotContains(t, body, `namespace="metrics-c"`, "Namespaces beyond the limit MUST NOT be used as label")
+	assert.Contains(t, body, `mailmock_repository_objects{namespace="other"} 2`, "")
+}
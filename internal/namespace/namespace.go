@@ -0,0 +1,84 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package namespace derives the namespaces in which transactions are stored.
+package namespace
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+)
+
+// Modes of derivation
+const (
+	ModeNone   = ""       // every transaction is stored in the default namespace
+	ModeDomain = "domain" // namespaces are the domains of the recipients
+	ModeHeader = "header" // namespace is the value of a header of the mail (header:<Name>)
+	ModePort   = "port"   // namespace is the port of the SMTP listener
+	ModeAuth   = "auth"   // namespace is the login of the authenticated client
+)
+
+// Func returns the names of the namespaces in which a transaction must be stored.
+// The empty name designates the default namespace.
+type Func func(tr *smtpd.Transaction) []string
+
+// Parse returns the Func implementing the mode, port is the port of the SMTP listener.
+func Parse(mode string, port string) (Func, error) {
+	switch {
+	case mode == ModeNone:
+		return func(*smtpd.Transaction) []string { return []string{""} }, nil
+	case mode == ModeDomain:
+		return byDomain, nil
+	case strings.HasPrefix(mode, ModeHeader+":") && len(mode) > len(ModeHeader)+1:
+		return byHeader(mode[len(ModeHeader)+1:]), nil
+	case mode == ModePort:
+		return func(*smtpd.Transaction) []string { return []string{port} }, nil
+	case mode == ModeAuth:
+		return func(tr *smtpd.Transaction) []string { return []string{tr.User} }, nil
+	}
+	return nil, fmt.Errorf("invalid namespace mode %q (valid modes are: domain, header:<Name>, port, auth)", mode)
+}
+
+// byDomain returns each distinct domain of the recipients.
+func byDomain(tr *smtpd.Transaction) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, rcpt := range tr.Mail.Envelope.Recipients {
+		address := strings.Trim(rcpt, "<>")
+		name := ""
+		if i := strings.LastIndex(address, "@"); i >= 0 {
+			name = strings.ToLower(address[i+1:])
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{""}
+	}
+	return names
+}
+
+// byHeader returns the value of the header.
+func byHeader(header string) Func {
+	return func(tr *smtpd.Transaction) []string {
+		return []string{tr.Mail.Header(header)}
+	}
+}
@@ -0,0 +1,58 @@
+package namespace_test
+
+import (
+	"testing"
+
+	"github.com/adrienaury/mailmock/internal/namespace"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+var tr = &smtpd.Transaction{Mail: smtpd.Mail{
+	Envelope: smtpd.Envelope{Sender: "<sender@example.com>", Recipients: []string{"<a@Example.org>", "<b@example.net>", "<c@example.org>"}},
+	Content:  []string{"Subject: test", "X-Test-Suite: suite-1", "X-Folded: a", " b", "", "X-Test-Suite: body"},
+}}
+
+func TestNamespaceNone(t *testing.T) {
+	f, err := namespace.Parse("", "25")
+	assert.NoError(t, err, "")
+	assert.Equal(t, []string{""}, f(tr), "")
+}
+
+func TestNamespaceDomain(t *testing.T) {
+	f, err := namespace.Parse("domain", "25")
+	assert.NoError(t, err, "")
+	assert.Equal(t, []string{"example.org", "example.net"}, f(tr), "")
+	assert.Equal(t, []string{""}, f(smtpd.NewTransaction()), "")
+}
+
+func TestNamespaceHeader(t *testing.T) {
+	f, err := namespace.Parse("header:x-test-suite", "25")
+	assert.NoError(t, err, "")
+	assert.Equal(t, []string{"suite-1"}, f(tr), "")
+
+	f, err = namespace.Parse("header:X-Missing", "25")
+	assert.NoError(t, err, "")
+	assert.Equal(t, []string{""}, f(tr), "")
+
+	_, err = namespace.Parse("header:", "25")
+	assert.Error(t, err, "")
+}
+
+func TestNamespacePort(t *testing.T) {
+	f, err := namespace.Parse("port", "2525")
+	assert.NoError(t, err, "")
+	assert.Equal(t, []string{"2525"}, f(tr), "")
+}
+
+func TestNamespaceAuth(t *testing.T) {
+	f, err := namespace.Parse("auth", "25")
+	assert.NoError(t, err, "")
+	assert.Equal(t, []string{""}, f(tr), "Transactions of anonymous clients MUST be stored in the default namespace")
+	assert.Equal(t, []string{"bob"}, f(&smtpd.Transaction{User: "bob"}), "")
+}
+
+func TestNamespaceInvalid(t *testing.T) {
+	_, err := namespace.Parse("fake", "25")
+	assert.Error(t, err, "")
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package pop3d serves the mails of the repository over POP3 (RFC 1939), each recipient address is a mailbox.
+package pop3d
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+)
+
+// Maildrop gives access to the mails of mailboxes.
+type Maildrop interface {
+	// Messages returns the completed transactions of the mailbox, in order of reception.
+	Messages(mailbox string) []*smtpd.Transaction
+	// Delete removes the transactions from the mailbox.
+	Delete(mailbox string, trs []*smtpd.Transaction)
+}
+
+// RepositoryMaildrop is the Maildrop of transactions stored in the repository, in every namespace.
+// A transaction is removed from the repository once it has been deleted from the mailboxes of all its recipients.
+type RepositoryMaildrop struct {
+	mutex   sync.Mutex
+	deleted map[*smtpd.Transaction]map[string]bool // mailboxes from which the transaction has been deleted
+}
+
+// NewRepositoryMaildrop returns the Maildrop of transactions stored in the repository.
+func NewRepositoryMaildrop() *RepositoryMaildrop {
+	return &RepositoryMaildrop{deleted: map[*smtpd.Transaction]map[string]bool{}}
+}
+
+// repositories returns the repositories of the default namespace and of all other namespaces.
+func repositories() []*repository.Repository {
+	repos := []*repository.Repository{repository.Namespace("")}
+	for _, name := range repository.Namespaces() {
+		if repo := repository.Find(name); repo != nil {
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+// Messages returns the completed transactions of the mailbox, a transaction stored in several namespaces is returned once.
+// Deletions of transactions that are no longer stored are forgotten.
+func (m *RepositoryMaildrop) Messages(mailbox string) []*smtpd.Transaction {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stored := map[*smtpd.Transaction]bool{}
+	seen := map[*smtpd.Transaction]bool{}
+	messages := []*smtpd.Transaction{}
+	for _, repo := range repositories() {
+		objects, _ := repo.All(0, repo.Len())
+		ids := make([]int, 0, len(objects))
+		for id := range objects {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			tr, ok := objects[id].(*smtpd.Transaction)
+			if ok {
+				stored[tr] = true
+			}
+			if !ok || seen[tr] || tr.State != smtpd.TSCompleted || !isRecipient(tr, mailbox) || m.deleted[tr][mailboxName(mailbox)] {
+				continue
+			}
+			seen[tr] = true
+			messages = append(messages, tr)
+		}
+	}
+	for tr := range m.deleted {
+		if !stored[tr] {
+			delete(m.deleted, tr)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Received.Before(messages[j].Received) })
+	return messages
+}
+
+// Delete removes the transactions from the mailbox, and from the repository if no other recipient can read them.
+func (m *RepositoryMaildrop) Delete(mailbox string, trs []*smtpd.Transaction) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, tr := range trs {
+		if m.deleted[tr] == nil {
+			m.deleted[tr] = map[string]bool{}
+		}
+		m.deleted[tr][mailboxName(mailbox)] = true
+		remaining := false
+		for _, rcpt := range tr.Mail.Envelope.Recipients {
+			remaining = remaining || !m.deleted[tr][mailboxName(rcpt)]
+		}
+		if remaining {
+			continue
+		}
+		for _, repo := range repositories() {
+			repo.DeleteFunc(func(o interface{}) bool { return o == tr })
+		}
+		delete(m.deleted, tr)
+	}
+}
+
+// mailboxName returns the address without angle brackets, in lower case.
+func mailboxName(address string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+}
+
+func isRecipient(tr *smtpd.Transaction, mailbox string) bool {
+	for _, rcpt := range tr.Mail.Envelope.Recipients {
+		if mailboxName(rcpt) == mailboxName(mailbox) {
+			return true
+		}
+	}
+	return false
+}
+
+// uid returns the unique ID of the transaction, made of the ID of the session and the time of receipt.
+func uid(tr *smtpd.Transaction) string {
+	return fmt.Sprintf("%v.%v", tr.Session, tr.Received.UnixNano())
+}
@@ -0,0 +1,285 @@
+package pop3d_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5" // #nosec G501
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/pop3d"
+	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+// store stores a completed transaction in the namespace.
+func store(ns string, recipients []string, content ...string) *smtpd.Transaction {
+	tr := smtpd.NewTransaction()
+	tr.State = smtpd.TSCompleted
+	tr.Session = "session"
+	tr.Received = time.Now()
+	tr.Mail.Envelope.Sender = "<alice@example.com>"
+	tr.Mail.Envelope.Recipients = recipients
+	tr.Mail.Content = content
+	repository.Namespace(ns).Store(tr)
+	return tr
+}
+
+// serve starts a server on an empty repository.
+func serve(t *testing.T, users map[string]string, config *tls.Config) string {
+	repository.Reset()
+	repository.Namespace("other").Reset()
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "")
+	stop := make(chan struct{})
+	srv := pop3d.NewServer("mockmail-pop3", "127.0.0.1", "0", pop3d.NewRepositoryMaildrop(), nil)
+	srv.SetUsers(users)
+	srv.SetTLS(config)
+	go func() { _ = srv.Serve(ln, stop) }()
+	t.Cleanup(func() { close(stop) })
+	return ln.Addr().String()
+}
+
+type client struct {
+	*textproto.Conn
+	t        *testing.T
+	greeting string
+}
+
+func dial(t *testing.T, addr string) *client {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err, "")
+	c := &client{textproto.NewConn(conn), t, ""}
+	c.greeting = c.line()
+	return c
+}
+
+func (c *client) line() string {
+	line, err := c.ReadLine()
+	assert.NoError(c.t, err, "")
+	return line
+}
+
+// cmd sends the command and returns the first line of the reply.
+func (c *client) cmd(format string, args ...interface{}) string {
+	assert.NoError(c.t, c.PrintfLine(format, args...), "")
+	return c.line()
+}
+
+// lines returns the lines of a multi-line reply.
+func (c *client) lines() []string {
+	lines, err := c.ReadDotLines()
+	assert.NoError(c.t, err, "")
+	return lines
+}
+
+func TestRetrieve(t *testing.T) {
+	addr := serve(t, nil, nil)
+	store("", []string{"<bob@pop3.example.com>"}, "Subject: first", "", "Hello", ".dot")
+	store("other", []string{"<carol@pop3.example.com>"}, "Subject: not for bob", "", "Hello")
+	store("other", []string{"<BOB@pop3.example.com>"}, "Subject: second", "", "line 1", "line 2", "line 3")
+
+	c := dial(t, addr)
+	defer c.Close()
+	assert.Regexp(t, `^\+OK .* <[^>]+>$`, c.greeting, "Greeting MUST contain a timestamp for APOP")
+	assert.Equal(t, "+OK Capability list follows", c.cmd("CAPA"), "")
+	assert.Contains(t, c.lines(), "UIDL", "")
+	assert.Equal(t, "-ERR Command not valid in this state, authenticate with USER and PASS or APOP", c.cmd("STAT"), "")
+	assert.Equal(t, "+OK Send PASS", c.cmd("USER bob@pop3.example.com"), "")
+	assert.Equal(t, "+OK Maildrop has 2 messages", c.cmd("PASS anything"), "Any password MUST be accepted if no user is configured")
+
+	assert.Equal(t, "+OK 2 74", c.cmd("STAT"), "")
+	assert.Equal(t, "+OK 2 messages", c.cmd("LIST"), "")
+	assert.Equal(t, []string{"1 31", "2 43"}, c.lines(), "")
+	assert.Equal(t, "+OK 2 43", c.cmd("LIST 2"), "")
+	assert.Equal(t, "-ERR No such message", c.cmd("LIST 3"), "")
+	assert.Equal(t, "+OK 2 messages", c.cmd("UIDL"), "")
+	uids := c.lines()
+	assert.Len(t, uids, 2, "")
+	assert.Regexp(t, `^1 session\.\d+$`, uids[0], "")
+
+	assert.Equal(t, "+OK 31 octets", c.cmd("RETR 1"), "")
+	assert.Equal(t, []string{"Subject: first", "", "Hello", ".dot"}, c.lines(), "Lines starting with a dot MUST be dot-stuffed")
+	assert.Equal(t, "+OK 43 octets", c.cmd("TOP 2 1"), "")
+	assert.Equal(t, []string{"Subject: second", "", "line 1"}, c.lines(), "")
+	assert.Equal(t, "+OK 43 octets", c.cmd("TOP 2 0"), "")
+	assert.Equal(t, []string{"Subject: second", ""}, c.lines(), "")
+	assert.Equal(t, "+OK Mailmock POP3 server signing off", c.cmd("QUIT"), "")
+}
+
+func TestDelete(t *testing.T) {
+	addr := serve(t, nil, nil)
+	tr := store("", []string{"<dave@pop3.example.com>", "<erin@pop3.example.com>"}, "Subject: shared", "", "Hello")
+	store("", []string{"<dave@pop3.example.com>"}, "Subject: kept", "", "Hello")
+	deleted := func() bool {
+		objects, _ := repository.All(0, repository.Len())
+		for _, o := range objects {
+			if o == tr {
+				return false
+			}
+		}
+		return true
+	}
+
+	c := dial(t, addr)
+	c.cmd("USER dave@pop3.example.com")
+	c.cmd("PASS secret")
+	assert.Equal(t, "+OK Message 1 deleted", c.cmd("DELE 1"), "")
+	assert.Equal(t, "-ERR No such message", c.cmd("RETR 1"), "Deleted messages MUST NOT be accessible")
+	assert.Equal(t, "+OK 1 24", c.cmd("STAT"), "")
+	assert.Equal(t, "+OK Maildrop has 2 messages", c.cmd("RSET"), "")
+	assert.Equal(t, "+OK Message 1 deleted", c.cmd("DELE 1"), "")
+	c.cmd("QUIT")
+	c.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	c = dial(t, addr)
+	c.cmd("USER dave@pop3.example.com")
+	assert.Equal(t, "+OK Maildrop has 1 messages", c.cmd("PASS secret"), "")
+	c.cmd("QUIT")
+	c.Close()
+	assert.False(t, deleted(), "A message MUST be kept while other recipients can read it")
+
+	c = dial(t, addr)
+	c.cmd("USER erin@pop3.example.com")
+	assert.Equal(t, "+OK Maildrop has 1 messages", c.cmd("PASS secret"), "")
+	c.cmd("DELE 1")
+	c.Close() // without QUIT, nothing is deleted
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, deleted(), "Messages MUST only be deleted on QUIT")
+
+	c = dial(t, addr)
+	c.cmd("USER erin@pop3.example.com")
+	c.cmd("PASS secret")
+	c.cmd("DELE 1")
+	c.cmd("QUIT")
+	c.Close()
+	assert.True(t, deleted(), "A message deleted by all recipients MUST be removed from the repository")
+}
+
+func TestAuthentication(t *testing.T) {
+	addr := serve(t, map[string]string{"frank@pop3.example.com": "secret"}, nil)
+	store("", []string{"<frank@pop3.example.com>"}, "Subject: hello", "", "Hello")
+
+	c := dial(t, addr)
+	defer c.Close()
+	assert.Equal(t, "-ERR Send USER first", c.cmd("PASS secret"), "")
+	c.cmd("USER frank@pop3.example.com")
+	assert.Equal(t, "-ERR [AUTH] Invalid user name or password", c.cmd("PASS wrong"), "")
+	assert.Equal(t, "-ERR [AUTH] Invalid user name or digest", c.cmd("APOP frank@pop3.example.com 0123"), "")
+
+	timestamp := regexp.MustCompile(`<[^>]+>`).FindString(c.greeting)
+	digest := md5.Sum([]byte(timestamp + "secret")) // #nosec G401
+	assert.Equal(t, "+OK Maildrop has 1 messages", c.cmd("APOP frank@pop3.example.com %s", hex.EncodeToString(digest[:])), "")
+
+	other := dial(t, addr)
+	defer other.Close()
+	other.cmd("USER frank@pop3.example.com")
+	assert.Equal(t, "-ERR [IN-USE] Maildrop already locked", other.cmd("PASS secret"), "")
+	c.cmd("QUIT")
+	time.Sleep(100 * time.Millisecond)
+	other.cmd("USER frank@pop3.example.com")
+	assert.Equal(t, "+OK Maildrop has 1 messages", other.cmd("PASS secret"), "The mailbox MUST be unlocked at the end of the session")
+}
+
+func TestStartTLS(t *testing.T) {
+	addr := serve(t, nil, selfSigned(t))
+	c := dial(t, addr)
+	defer c.Close()
+	c.cmd("CAPA")
+	assert.Contains(t, c.lines(), "STLS", "")
+	assert.Equal(t, "+OK Begin TLS negotiation", c.cmd("STLS"), "")
+
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err, "")
+	defer conn.Close()
+	raw := textproto.NewConn(conn)
+	_, err = raw.ReadLine()
+	assert.NoError(t, err, "")
+	assert.NoError(t, raw.PrintfLine("STLS"), "")
+	line, err := raw.ReadLine()
+	assert.NoError(t, err, "")
+	assert.True(t, strings.HasPrefix(line, "+OK"), "")
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true}) // #nosec G402
+	assert.NoError(t, tlsConn.Handshake(), "")
+	secure := &client{textproto.NewConn(tlsConn), t, ""}
+	secure.cmd("CAPA")
+	assert.NotContains(t, secure.lines(), "STLS", "STLS MUST NOT be offered once the connection is encrypted")
+	assert.Equal(t, "-ERR Command not available", secure.cmd("STLS"), "")
+}
+
+func TestStartTLSStop(t *testing.T) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "")
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	srv := pop3d.NewServer("mockmail-pop3", "127.0.0.1", "0", pop3d.NewRepositoryMaildrop(), nil)
+	srv.SetTLS(selfSigned(t))
+	go func() {
+		_ = srv.Serve(ln, stop)
+		close(stopped)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err, "")
+	defer conn.Close()
+	raw := textproto.NewConn(conn)
+	_, err = raw.ReadLine()
+	assert.NoError(t, err, "")
+	assert.NoError(t, raw.PrintfLine("STLS"), "")
+	_, err = raw.ReadLine()
+	assert.NoError(t, err, "")
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true}) // #nosec G402
+	assert.NoError(t, tlsConn.Handshake(), "")
+	secure := &client{textproto.NewConn(tlsConn), t, ""}
+	secure.cmd("CAPA")
+	assert.NotContains(t, secure.lines(), "STLS", "")
+
+	close(stop)
+	assert.Equal(t, "-ERR Server shutting down", secure.line(), "Sessions over TLS MUST be stopped with the server")
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Server MUST stop once sessions are closed")
+	}
+}
+
+func TestMaildropForget(t *testing.T) {
+	repository.Reset()
+	tr := store("", []string{"<grace@pop3.example.com>", "<heidi@pop3.example.com>"}, "Subject: forgotten", "", "Hello")
+	m := pop3d.NewRepositoryMaildrop()
+	m.Delete("grace@pop3.example.com", []*smtpd.Transaction{tr})
+	assert.Empty(t, m.Messages("grace@pop3.example.com"), "")
+
+	repository.Reset()
+	assert.Empty(t, m.Messages("heidi@pop3.example.com"), "")
+	repository.Store(tr)
+	assert.Len(t, m.Messages("grace@pop3.example.com"), 1, "Deletions of transactions no longer stored MUST be forgotten")
+}
+
+func selfSigned(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err, "")
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package pop3d
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/log"
+)
+
+// Server is holding the POP3 server properties.
+type Server struct {
+	name      string
+	addr      string
+	maildrop  Maildrop
+	users     map[string]string // passwords by user name, any password is accepted if empty
+	tls       *tls.Config       // offer STLS (RFC 2595) if not nil
+	logger    log.Logger
+	waitGroup *sync.WaitGroup
+	mutex     sync.Mutex
+	locked    map[string]bool // mailboxes used by a session
+}
+
+// NewServer creates a POP3 server.
+func NewServer(name string, host string, port string, maildrop Maildrop, logger log.Logger) *Server {
+	if logger == nil {
+		logger = log.DefaultLogger
+	}
+	addr := net.JoinHostPort(host, port)
+	l := logger.WithFields(log.Fields{
+		log.FieldServer: name,
+		log.FieldListen: addr,
+	})
+	return &Server{
+		name:      name,
+		addr:      addr,
+		maildrop:  maildrop,
+		users:     map[string]string{},
+		logger:    l,
+		waitGroup: &sync.WaitGroup{},
+		locked:    map[string]bool{},
+	}
+}
+
+// SetUsers sets the accepted accounts, user names are mailbox addresses. Any password is accepted if empty.
+func (srv *Server) SetUsers(users map[string]string) {
+	srv.users = map[string]string{}
+	for username, password := range users {
+		srv.users[mailboxName(username)] = password
+	}
+}
+
+// SetTLS sets the configuration used to upgrade connections with STLS.
+func (srv *Server) SetTLS(config *tls.Config) {
+	srv.tls = config
+}
+
+// ListenAndServe starts listening for clients connection and serves POP3 commands.
+func (srv *Server) ListenAndServe(stop <-chan struct{}) error {
+	ln, err := net.Listen("tcp", srv.addr)
+	if err != nil {
+		srv.logger.Error("POP3 Server failed to start", log.Fields{log.FieldError: err})
+		return err
+	}
+	return srv.Serve(ln, stop)
+}
+
+// Serve accepts clients connection on the listener and serves POP3 commands, until stop is closed.
+// The listener is closed when Serve returns.
+func (srv *Server) Serve(ln net.Listener, stop <-chan struct{}) error {
+	srv.logger.Info("POP3 Server is listening")
+	go func() {
+		<-stop
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-stop:
+				srv.waitGroup.Wait()
+				srv.logger.Info("POP3 Server is stopped")
+				return nil
+			default:
+			}
+			srv.logger.Error("POP3 Server failed to accept connection", log.Fields{log.FieldError: err})
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		srv.waitGroup.Add(1)
+		go func() {
+			defer srv.waitGroup.Done()
+			newSession(srv, conn).serve(stop)
+		}()
+	}
+}
+
+// authenticate checks the password of the user.
+func (srv *Server) authenticate(username string, password string) bool {
+	if len(srv.users) == 0 {
+		return true
+	}
+	expected, ok := srv.users[mailboxName(username)]
+	return ok && expected == password
+}
+
+// secret returns the password of the user for APOP, false if the user is unknown.
+// Any digest is accepted if no account is configured.
+func (srv *Server) secret(username string) (string, bool) {
+	password, ok := srv.users[mailboxName(username)]
+	return password, ok
+}
+
+// lock gives exclusive access to the mailbox, false if it is already used by another session.
+func (srv *Server) lock(mailbox string) bool {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.locked[mailboxName(mailbox)] {
+		return false
+	}
+	srv.locked[mailboxName(mailbox)] = true
+	return true
+}
+
+func (srv *Server) unlock(mailbox string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	delete(srv.locked, mailboxName(mailbox))
+}
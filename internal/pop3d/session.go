@@ -0,0 +1,356 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+package pop3d
+
+import (
+	"crypto/md5" // #nosec G501 APOP digests are MD5 (RFC 1939)
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+)
+
+// states of a POP3 session (RFC 1939 §3)
+const (
+	stateAuthorization = "authorization"
+	stateTransaction   = "transaction"
+	stateClosed        = "closed"
+)
+
+var counter int64
+
+// session is a connection of a POP3 client.
+type session struct {
+	srv       *Server
+	netConn   net.Conn
+	conn      *textproto.Conn
+	logger    log.Logger
+	state     string
+	timestamp string // sent in the greeting, part of APOP digests
+	username  string // given with USER
+	mailbox   string // locked mailbox, in transaction state
+	messages  []*smtpd.Transaction
+	deleted   []bool
+	tls       bool
+	stopping  int32
+}
+
+func newSession(srv *Server, conn net.Conn) *session {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	s := &session{
+		srv:       srv,
+		netConn:   conn,
+		conn:      textproto.NewConn(conn),
+		state:     stateAuthorization,
+		timestamp: fmt.Sprintf("<%d.%d.%d@%s>", os.Getpid(), atomic.AddInt64(&counter, 1), time.Now().Unix(), hostname),
+	}
+	s.logger = srv.logger.WithFields(log.Fields{"remoteAddr": conn.RemoteAddr().String()})
+	return s
+}
+
+func (s *session) serve(stop <-chan struct{}) {
+	defer s.close()
+	done := make(chan struct{})
+	defer close(done)
+	conn := s.netConn // the TLS connection negotiated with STLS reads from it
+	go func() {
+		select {
+		case <-stop:
+			atomic.StoreInt32(&s.stopping, 1)
+			_ = conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	s.logger.Info("Initiated new POP3 session")
+	if err := s.conn.PrintfLine("+OK Mailmock POP3 server ready %s", s.timestamp); err != nil {
+		return
+	}
+	for s.state != stateClosed {
+		// POP3 server MAY have an inactivity autologout timer of at least 10 minutes (RFC 1939 §3)
+		_ = s.netConn.SetReadDeadline(time.Now().Add(10 * time.Minute))
+		input, err := s.conn.ReadLine()
+		if err != nil {
+			if atomic.LoadInt32(&s.stopping) == 1 {
+				_ = s.conn.PrintfLine("-ERR Server shutting down")
+			}
+			s.logger.Warn("POP3 session ended without QUIT", log.Fields{log.FieldError: err})
+			return
+		}
+		fields := strings.Fields(input)
+		if len(fields) == 0 {
+			fields = []string{""}
+		}
+		name := strings.ToUpper(fields[0])
+		s.logger.Debug("Received command", log.Fields{log.FieldCommand: name})
+		upgrade, err := s.receive(name, fields[1:])
+		if err != nil {
+			s.logger.Error("Network error, quitting", log.Fields{log.FieldError: err})
+			return
+		}
+		if upgrade {
+			tlsConn := tls.Server(s.netConn, s.srv.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				s.logger.Error("TLS negotiation failed, quitting", log.Fields{log.FieldError: err})
+				return
+			}
+			s.netConn, s.conn, s.tls = tlsConn, textproto.NewConn(tlsConn), true
+		}
+	}
+}
+
+// close releases the mailbox, deleted messages are kept unless the session ended with QUIT.
+func (s *session) close() {
+	if s.mailbox != "" {
+		s.srv.unlock(s.mailbox)
+	}
+	s.conn.Close()
+	s.logger.Info("Closed POP3 session")
+}
+
+// receive processes a command and sends the reply, it returns true if a TLS negotiation must follow.
+func (s *session) receive(name string, args []string) (bool, error) {
+	if name == "QUIT" {
+		return false, s.quit()
+	}
+	if name == "CAPA" {
+		return false, s.capabilities()
+	}
+	if s.state == stateAuthorization {
+		switch name {
+		case "USER":
+			return false, s.user(args)
+		case "PASS":
+			return false, s.pass(args)
+		case "APOP":
+			return false, s.apop(args)
+		case "STLS":
+			return s.startTLS()
+		}
+		return false, s.err("Command not valid in this state, authenticate with USER and PASS or APOP")
+	}
+	switch name {
+	case "STAT":
+		count, size := 0, 0
+		for i, tr := range s.messages {
+			if !s.deleted[i] {
+				count, size = count+1, size+tr.Mail.Size()
+			}
+		}
+		return false, s.ok("%d %d", count, size)
+	case "LIST":
+		return false, s.list(args, func(tr *smtpd.Transaction) string { return strconv.Itoa(tr.Mail.Size()) })
+	case "UIDL":
+		return false, s.list(args, uid)
+	case "RETR":
+		return false, s.retrieve(args, -1)
+	case "TOP":
+		if len(args) != 2 {
+			return false, s.err("Usage: TOP <message> <lines>")
+		}
+		lines, err := strconv.Atoi(args[1])
+		if err != nil || lines < 0 {
+			return false, s.err("Invalid number of lines")
+		}
+		return false, s.retrieve(args[:1], lines)
+	case "DELE":
+		i, ok := s.message(args)
+		if !ok {
+			return false, s.err("No such message")
+		}
+		s.deleted[i] = true
+		return false, s.ok("Message %d deleted", i+1)
+	case "RSET":
+		s.deleted = make([]bool, len(s.messages))
+		return false, s.ok("Maildrop has %d messages", len(s.messages))
+	case "NOOP":
+		return false, s.ok("")
+	}
+	return false, s.err("Unknown command")
+}
+
+func (s *session) ok(format string, a ...interface{}) error {
+	return s.conn.PrintfLine("+OK "+format, a...)
+}
+
+func (s *session) err(msg string) error {
+	return s.conn.PrintfLine("-ERR %s", msg)
+}
+
+// capabilities replies to CAPA (RFC 2449).
+func (s *session) capabilities() error {
+	capabilities := []string{"TOP", "UIDL", "USER", "RESP-CODES", "IMPLEMENTATION Mailmock"}
+	if s.srv.tls != nil && !s.tls && s.state == stateAuthorization {
+		capabilities = append(capabilities, "STLS")
+	}
+	if err := s.ok("Capability list follows"); err != nil {
+		return err
+	}
+	return s.writeLines(capabilities)
+}
+
+func (s *session) user(args []string) error {
+	if len(args) != 1 {
+		return s.err("Usage: USER <mailbox>")
+	}
+	s.username = args[0]
+	return s.ok("Send PASS")
+}
+
+func (s *session) pass(args []string) error {
+	if s.username == "" {
+		return s.err("Send USER first")
+	}
+	username := s.username
+	s.username = ""
+	if !s.srv.authenticate(username, strings.Join(args, " ")) {
+		return s.err("[AUTH] Invalid user name or password")
+	}
+	return s.open(username)
+}
+
+func (s *session) apop(args []string) error {
+	if len(args) != 2 {
+		return s.err("Usage: APOP <mailbox> <digest>")
+	}
+	if password, ok := s.srv.secret(args[0]); ok || len(s.srv.users) > 0 {
+		digest := md5.Sum([]byte(s.timestamp + password)) // #nosec G401
+		if !ok || !strings.EqualFold(hex.EncodeToString(digest[:]), args[1]) {
+			return s.err("[AUTH] Invalid user name or digest")
+		}
+	}
+	return s.open(args[0])
+}
+
+// open locks the mailbox and reads its messages, the session enters the transaction state.
+func (s *session) open(mailbox string) error {
+	if !s.srv.lock(mailbox) {
+		return s.err("[IN-USE] Maildrop already locked")
+	}
+	s.mailbox = mailbox
+	s.messages = s.srv.maildrop.Messages(mailbox)
+	s.deleted = make([]bool, len(s.messages))
+	s.state = stateTransaction
+	s.logger.Info("Opened mailbox", log.Fields{"mailbox": mailbox, "messages": len(s.messages)})
+	return s.ok("Maildrop has %d messages", len(s.messages))
+}
+
+func (s *session) startTLS() (bool, error) {
+	if s.srv.tls == nil || s.tls {
+		return false, s.err("Command not available")
+	}
+	return true, s.ok("Begin TLS negotiation")
+}
+
+// quit deletes the messages marked as deleted, if the session is in transaction state (RFC 1939 §6).
+func (s *session) quit() error {
+	if s.state == stateTransaction {
+		deleted := []*smtpd.Transaction{}
+		for i, tr := range s.messages {
+			if s.deleted[i] {
+				deleted = append(deleted, tr)
+			}
+		}
+		s.srv.maildrop.Delete(s.mailbox, deleted)
+		s.logger.Info("Deleted messages", log.Fields{"mailbox": s.mailbox, "messages": len(deleted)})
+	}
+	s.state = stateClosed
+	return s.ok("Mailmock POP3 server signing off")
+}
+
+// message returns the index of the message given as argument, false if it doesn't exist or is deleted.
+func (s *session) message(args []string) (int, bool) {
+	if len(args) != 1 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(s.messages) || s.deleted[n-1] {
+		return 0, false
+	}
+	return n - 1, true
+}
+
+// list replies to LIST and UIDL, with the value of one message or of all messages.
+func (s *session) list(args []string, value func(tr *smtpd.Transaction) string) error {
+	if len(args) > 0 {
+		i, ok := s.message(args)
+		if !ok {
+			return s.err("No such message")
+		}
+		return s.ok("%d %s", i+1, value(s.messages[i]))
+	}
+	lines := []string{}
+	for i, tr := range s.messages {
+		if !s.deleted[i] {
+			lines = append(lines, fmt.Sprintf("%d %s", i+1, value(tr)))
+		}
+	}
+	if err := s.ok("%d messages", len(lines)); err != nil {
+		return err
+	}
+	return s.writeLines(lines)
+}
+
+// retrieve replies to RETR, or to TOP with the number of lines of the body (-1 for RETR).
+func (s *session) retrieve(args []string, lines int) error {
+	i, ok := s.message(args)
+	if !ok {
+		return s.err("No such message")
+	}
+	content := s.messages[i].Mail.Content
+	if lines >= 0 {
+		end := len(content)
+		for j, line := range content {
+			if line == "" {
+				end = j + 1 + lines
+				break
+			}
+		}
+		if end < len(content) {
+			content = content[:end]
+		}
+	}
+	if err := s.ok("%d octets", s.messages[i].Mail.Size()); err != nil {
+		return err
+	}
+	return s.writeLines(content)
+}
+
+// writeLines sends a multi-line response, lines starting with "." are dot-stuffed.
+func (s *session) writeLines(lines []string) error {
+	w := s.conn.DotWriter()
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
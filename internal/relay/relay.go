@@ -0,0 +1,210 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package relay forwards transactions to downstream SMTP servers.
+package relay
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+)
+
+// ErrNoRelay is returned by Release if no server is configured.
+var ErrNoRelay = errors.New("no relay configured")
+
+// Config is the configuration of a downstream server.
+type Config struct {
+	Server     string   `mapstructure:"server"`   // host and port of the server
+	StartTLS   bool     `mapstructure:"startTLS"` // require STARTTLS
+	Insecure   bool     `mapstructure:"insecure"` // do not verify the certificate of the server
+	Username   string   `mapstructure:"username"` // authenticate with AUTH PLAIN if not empty
+	Password   string   `mapstructure:"password"`
+	Recipients []string `mapstructure:"recipients"` // forward only recipients whose address contains one of these values
+	Domains    []string `mapstructure:"domains"`    // forward only recipients belonging to one of these domains
+}
+
+// selectRecipients returns the recipients forwarded to the server.
+func (c Config) selectRecipients(recipients []string) []string {
+	if len(c.Recipients) == 0 && len(c.Domains) == 0 {
+		return recipients
+	}
+	selected := []string{}
+	for _, rcpt := range recipients {
+		if c.match(strings.ToLower(strings.Trim(rcpt, "<>"))) {
+			selected = append(selected, rcpt)
+		}
+	}
+	return selected
+}
+
+func (c Config) match(addr string) bool {
+	for _, part := range c.Recipients {
+		if strings.Contains(addr, strings.ToLower(part)) {
+			return true
+		}
+	}
+	for _, domain := range c.Domains {
+		if strings.HasSuffix(addr, "@"+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Relay forwards mails to downstream servers.
+type Relay struct {
+	servers []Config
+	timeout time.Duration
+	logger  log.Logger
+	wg      sync.WaitGroup
+}
+
+// New creates a relay to the given servers, with a timeout of 30 seconds.
+func New(servers []Config, logger log.Logger) *Relay {
+	if logger == nil {
+		logger = log.DefaultLogger
+	}
+	return &Relay{
+		servers: servers,
+		timeout: 30 * time.Second,
+		logger:  logger,
+	}
+}
+
+// SetTimeout sets the maximum duration of the forwarding of a mail to a server.
+func (r *Relay) SetTimeout(timeout time.Duration) {
+	r.timeout = timeout
+}
+
+// Handler returns a handler forwarding completed transactions to the servers selecting some of their recipients,
+// to use in a smtpd.Chain. The mail is forwarded in the background, so the reply to DATA does not wait for the
+// servers and never depends on them. Outcomes are added to the transaction once known.
+func (r *Relay) Handler() smtpd.Handler {
+	return func(tr *smtpd.Transaction) error {
+		if tr.State != smtpd.TSCompleted {
+			return nil
+		}
+		for _, server := range r.servers {
+			if recipients := server.selectRecipients(tr.Mail.Envelope.Recipients); len(recipients) > 0 {
+				r.wg.Add(1)
+				go func(server Config) {
+					defer r.wg.Done()
+					tr.AddRelay(r.send(server, tr.Mail.Envelope.Sender, recipients, tr.Mail.Content))
+				}(server)
+			}
+		}
+		return nil
+	}
+}
+
+// Wait waits until mails being forwarded in the background are sent, it returns false if the timeout expired first.
+func (r *Relay) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Release forwards the mail of a transaction to the given recipients with the first server, whatever its filters.
+// The outcome is added to the transaction and returned.
+func (r *Relay) Release(tr *smtpd.Transaction, recipients []string) (smtpd.Relay, error) {
+	if len(r.servers) == 0 {
+		return smtpd.Relay{}, ErrNoRelay
+	}
+	relay := r.send(r.servers[0], tr.Mail.Envelope.Sender, recipients, tr.Mail.Content)
+	tr.AddRelay(relay)
+	return relay, nil
+}
+
+// send forwards a mail to a server and returns the outcome.
+func (r *Relay) send(server Config, sender string, recipients []string, content []string) smtpd.Relay {
+	relay := smtpd.Relay{Time: time.Now(), Server: server.Server, Recipients: recipients}
+	if err := r.forward(server, sender, recipients, content); err != nil {
+		relay.Error = err.Error()
+		r.logger.Warn("Failed to relay mail", log.Fields{log.FieldError: err, log.FieldRelay: server.Server})
+	} else {
+		r.logger.Debug("Relayed mail", log.Fields{log.FieldRelay: server.Server})
+	}
+	return relay
+}
+
+func (r *Relay) forward(server Config, sender string, recipients []string, content []string) error {
+	host, _, err := net.SplitHostPort(server.Server)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", server.Server, r.timeout)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(r.timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if server.StartTLS {
+		if err := c.StartTLS(&tls.Config{ServerName: host, InsecureSkipVerify: server.Insecure}); err != nil { // #nosec G402
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if server.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", server.Username, server.Password, host)); err != nil {
+			return fmt.Errorf("AUTH: %w", err)
+		}
+	}
+	if err := c.Mail(strings.Trim(sender, "<>")); err != nil {
+		return fmt.Errorf("MAIL: %w", err)
+	}
+	for _, rcpt := range recipients {
+		if err := c.Rcpt(strings.Trim(rcpt, "<>")); err != nil {
+			return fmt.Errorf("RCPT %v: %w", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	if _, err := w.Write([]byte(strings.Join(content, "\r\n") + "\r\n")); err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	return c.Quit()
+}
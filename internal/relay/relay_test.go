@@ -0,0 +1,129 @@
+package relay_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/relay"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+// downstream starts a SMTP server and returns its address and the transactions it receives.
+func downstream(t *testing.T) (string, chan *smtpd.Transaction) {
+	received := make(chan *smtpd.Transaction, 10)
+	var th smtpd.TransactionHandler = func(tr *smtpd.Transaction) {
+		received <- tr
+	}
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "")
+	stop := make(chan struct{})
+	srv := smtpd.NewServer("downstream", "127.0.0.1", "0", &th, nil)
+	go func() { _ = srv.Serve(ln, stop) }()
+	t.Cleanup(func() {
+		close(stop)
+		ln.Close()
+	})
+	return ln.Addr().String(), received
+}
+
+func transaction() *smtpd.Transaction {
+	return &smtpd.Transaction{
+		State: smtpd.TSCompleted,
+		Mail: smtpd.Mail{
+			Envelope: smtpd.Envelope{Sender: "<sender@example.org>", Recipients: []string{"<bob@example.com>", "<alice@example.net>"}},
+			Content:  []string{"Subject: test", "", "body", ".starts with a dot"},
+		},
+	}
+}
+
+func TestHandler(t *testing.T) {
+	addr, received := downstream(t)
+	r := relay.New([]relay.Config{{Server: addr, Domains: []string{"example.com"}}}, nil)
+
+	tr := transaction()
+	assert.NoError(t, r.Handler()(tr), "Failures of the relay MUST NOT change the reply")
+	assert.True(t, r.Wait(5*time.Second), "")
+	relays := tr.GetRelays()
+	assert.Len(t, relays, 1, "")
+	assert.Equal(t, addr, relays[0].Server, "")
+	assert.Equal(t, []string{"<bob@example.com>"}, relays[0].Recipients, "Only recipients selected by the filters MUST be relayed")
+	assert.Empty(t, relays[0].Error, "")
+
+	select {
+	case relayed := <-received:
+		assert.Equal(t, "<sender@example.org>", relayed.Mail.Envelope.Sender, "")
+		assert.Equal(t, []string{"<bob@example.com>"}, relayed.Mail.Envelope.Recipients, "")
+		assert.Equal(t, []string{"Subject: test", "", "body", ".starts with a dot"}, relayed.Mail.Content, "")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Mail not relayed")
+	}
+
+	tr = transaction()
+	tr.State = smtpd.TSAborted
+	assert.NoError(t, r.Handler()(tr), "")
+	assert.True(t, r.Wait(5*time.Second), "")
+	assert.Empty(t, tr.GetRelays(), "Aborted transactions MUST NOT be relayed")
+}
+
+func TestRelayFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "")
+	addr := ln.Addr().String()
+	ln.Close()
+
+	r := relay.New([]relay.Config{{Server: addr}}, nil)
+	r.SetTimeout(time.Second)
+	tr := transaction()
+	assert.NoError(t, r.Handler()(tr), "A failure MUST NOT change the reply")
+	assert.True(t, r.Wait(5*time.Second), "")
+	relays := tr.GetRelays()
+	assert.Len(t, relays, 1, "")
+	assert.NotEmpty(t, relays[0].Error, "The failure MUST be recorded")
+}
+
+func TestHandlerAsync(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "")
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	r := relay.New([]relay.Config{{Server: ln.Addr().String()}}, nil)
+	r.SetTimeout(time.Second)
+	tr := transaction()
+	start := time.Now()
+	assert.NoError(t, r.Handler()(tr), "")
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "The handler MUST NOT wait for the server")
+	assert.True(t, r.Wait(5*time.Second), "")
+	relays := tr.GetRelays()
+	assert.Len(t, relays, 1, "")
+	assert.NotEmpty(t, relays[0].Error, "A server that does not reply MUST be recorded as a failure")
+	(<-accepted).Close()
+}
+
+func TestRelease(t *testing.T) {
+	_, err := relay.New(nil, nil).Release(transaction(), []string{"carol@example.org"})
+	assert.ErrorIs(t, err, relay.ErrNoRelay, "")
+
+	addr, received := downstream(t)
+	r := relay.New([]relay.Config{{Server: addr, Domains: []string{"example.com"}}}, nil)
+	tr := transaction()
+	outcome, err := r.Release(tr, []string{"carol@example.org"})
+	assert.NoError(t, err, "")
+	assert.Empty(t, outcome.Error, "")
+	assert.Equal(t, []smtpd.Relay{outcome}, tr.GetRelays(), "The outcome MUST be recorded in the transaction")
+
+	select {
+	case relayed := <-received:
+		assert.Equal(t, []string{"<carol@example.org>"}, relayed.Mail.Envelope.Recipients, "Released mails MUST ignore the filters")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Mail not released")
+	}
+}
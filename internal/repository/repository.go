@@ -16,53 +16,401 @@
 // along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
 
 // Package repository handles storage access for Mailmock REST API.
+//
+// Each stored object is given an ID, IDs are allocated in sequence and are never reused :
+// deleting, evicting or resetting objects leaves a gap in the sequence.
+//
+// Objects can be stored in separate namespaces, each namespace has its own sequence of IDs.
+// Package level functions operate on the default namespace.
 package repository
 
-var storedObjects = []interface{}{}
+import (
+	"sort"
+	"sync"
+	"time"
+)
 
-// Store stores th object and gives it an ID.
+// Sizer is implemented by objects that know their size in bytes.
+type Sizer interface {
+	Size() int
+}
+
+// Retention limits the content of a repository, the oldest objects are evicted first.
+// A zero value means no limit.
+type Retention struct {
+	MaxCount int           // maximum number of objects
+	MaxBytes int           // maximum total size of objects (only objects implementing Sizer count)
+	TTL      time.Duration // maximum age of objects
+}
+
+// Stats gives information about the content of a repository.
+type Stats struct {
+	Count   int `json:"count"`   // number of objects stored
+	Bytes   int `json:"bytes"`   // total size of objects stored
+	NextID  int `json:"nextID"`  // ID of the next object to be stored
+	Deleted int `json:"deleted"` // number of objects deleted
+	Evicted int `json:"evicted"` // number of objects evicted by the retention policy
+}
+
+type entry struct {
+	object interface{}
+	stored time.Time
+	size   int
+}
+
+// Repository holds stored objects of a namespace.
+type Repository struct {
+	mutex     sync.RWMutex
+	entries   []entry // entries[0] holds object with ID offset
+	offset    int
+	stats     Stats
+	retention Retention
+	used      time.Time // last time the namespace was requested, guarded by namespacesMutex
+}
+
+// New returns a new empty Repository.
+func New() *Repository {
+	return &Repository{entries: []entry{}}
+}
+
+var (
+	defaultRepository  = New()
+	sessionRepository  = New()
+	deliveryRepository = New()
+	defaultRetention   Retention
+	namespacesMutex    sync.RWMutex
+	namespaces         = map[string]*Repository{}
+)
+
+// SetRetention sets the retention policy of the default namespace, of every namespace and of
+// the sessions and deliveries repositories, each of them is limited independently.
+func SetRetention(retention Retention) {
+	namespacesMutex.Lock()
+	defer namespacesMutex.Unlock()
+	defaultRetention = retention
+	defaultRepository.SetRetention(retention)
+	sessionRepository.SetRetention(retention)
+	deliveryRepository.SetRetention(retention)
+	for _, repo := range namespaces {
+		repo.SetRetention(retention)
+	}
+}
+
+// Namespace returns the repository of the given namespace, it is created if needed.
+// The empty name designates the default namespace.
+func Namespace(name string) *Repository {
+	if name == "" {
+		return defaultRepository
+	}
+	namespacesMutex.Lock()
+	defer namespacesMutex.Unlock()
+	repo, ok := namespaces[name]
+	if !ok {
+		repo = New()
+		repo.retention = defaultRetention
+		namespaces[name] = repo
+	}
+	repo.used = time.Now()
+	return repo
+}
+
+// Sweep evicts expired objects of every repository at each interval until stop is closed,
+// namespaces left empty and not requested during the last interval are removed.
+func Sweep(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			sweep(now.Add(-interval))
+		}
+	}
+}
+
+// sweep evicts expired objects and removes empty namespaces not requested since the given time.
+func sweep(since time.Time) {
+	defaultRepository.expire()
+	sessionRepository.expire()
+	deliveryRepository.expire()
+	namespacesMutex.Lock()
+	defer namespacesMutex.Unlock()
+	for name, repo := range namespaces {
+		repo.expire()
+		if repo.used.Before(since) && repo.Stats().Count == 0 {
+			delete(namespaces, name)
+		}
+	}
+}
+
+// Sessions returns the repository of session records, it doesn't belong to any namespace.
+func Sessions() *Repository {
+	return sessionRepository
+}
+
+// Deliveries returns the repository of webhook deliveries, it doesn't belong to any namespace.
+func Deliveries() *Repository {
+	return deliveryRepository
+}
+
+// Find returns the repository of the given namespace, or nil if the namespace doesn't exist.
+func Find(name string) *Repository {
+	if name == "" {
+		return defaultRepository
+	}
+	namespacesMutex.RLock()
+	defer namespacesMutex.RUnlock()
+	return namespaces[name]
+}
+
+// Remove removes the given namespace and its objects, the default namespace can't be removed.
+func Remove(name string) {
+	namespacesMutex.Lock()
+	defer namespacesMutex.Unlock()
+	delete(namespaces, name)
+}
+
+// Namespaces returns the sorted names of all namespaces, excluding the default namespace.
+func Namespaces() []string {
+	namespacesMutex.RLock()
+	defer namespacesMutex.RUnlock()
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Store stores th object in the default namespace and gives it an ID.
 func Store(o interface{}) int {
-	id := len(storedObjects)
-	storedObjects = append(storedObjects, o)
+	return defaultRepository.Store(o)
+}
+
+// Use returns the object with ID or nil from the default namespace.
+func Use(id int) interface{} {
+	return defaultRepository.Use(id)
+}
+
+// All returns all objects currently stored in the default namespace.
+func All(from, limit int) (map[int]interface{}, bool) {
+	return defaultRepository.All(from, limit)
+}
+
+// Delete removes the object with ID from the default namespace, returns false if there was no such object.
+func Delete(id int) bool {
+	return defaultRepository.Delete(id)
+}
+
+// DeleteFunc removes all objects of the default namespace for which match returns true, and returns their IDs.
+func DeleteFunc(match func(interface{}) bool) []int {
+	return defaultRepository.DeleteFunc(match)
+}
+
+// Reset removes all objects in the default namespace.
+func Reset() {
+	defaultRepository.Reset()
+}
+
+// Len gives the total number of IDs allocated in the default namespace, including those of deleted objects.
+func Len() int {
+	return defaultRepository.Len()
+}
+
+// SetRetention sets the retention policy and evicts objects accordingly.
+func (repo *Repository) SetRetention(retention Retention) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	repo.retention = retention
+	repo.evict(time.Now())
+}
+
+// Store stores th object and gives it an ID.
+func (repo *Repository) Store(o interface{}) int {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	id := repo.offset + len(repo.entries)
+	size := 0
+	if sizer, ok := o.(Sizer); ok {
+		size = sizer.Size()
+	}
+	repo.entries = append(repo.entries, entry{o, time.Now(), size})
+	repo.stats.Count++
+	repo.stats.Bytes += size
+	repo.evict(time.Now())
 	return id
 }
 
 // Use returns the object with ID or nil.
-func Use(id int) interface{} {
-	if id < len(storedObjects) {
-		return storedObjects[id]
+func (repo *Repository) Use(id int) interface{} {
+	repo.expire()
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+	if id >= repo.offset && id < repo.offset+len(repo.entries) {
+		return repo.entries[id-repo.offset].object
 	}
 	return nil
 }
 
-// All returns all objects currently stored.
-func All(from, limit int) (map[int]interface{}, bool) {
-	if from < len(storedObjects) {
-		if from+limit < len(storedObjects) {
-			return tomap(from, from+limit), false
+// All returns objects with IDs in the range [from, from+limit), and true if
+// the range contains all objects currently stored.
+func (repo *Repository) All(from, limit int) (map[int]interface{}, bool) {
+	repo.expire()
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+	end := repo.offset + len(repo.entries)
+	if from < end {
+		if from+limit < end {
+			return repo.tomap(from, from+limit), false
 		}
-		return tomap(from, len(storedObjects)), from == 0
+		return repo.tomap(from, end), from <= repo.offset
 	}
-	if from > len(storedObjects) {
+	if from > end {
 		return nil, false
 	}
 	return map[int]interface{}{}, from == 0
 }
 
-// Reset removes all objects in storage.
-func Reset() {
-	storedObjects = []interface{}{}
+// Filter returns at most limit objects with IDs from the given one for which match returns true, the ID
+// of the first matching object not returned (the next ID to be allocated if there is none) and the
+// total number of matching objects.
+func (repo *Repository) Filter(from, limit int, match func(interface{}) bool) (map[int]interface{}, int, int) {
+	repo.expire()
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+	objects := map[int]interface{}{}
+	next := repo.offset + len(repo.entries)
+	if from > next {
+		next = from
+	}
+	total := 0
+	for i, e := range repo.entries {
+		id := repo.offset + i
+		if e.object == nil || !match(e.object) {
+			continue
+		}
+		total++
+		if id < from {
+			continue
+		}
+		if len(objects) < limit {
+			objects[id] = e.object
+		} else if len(objects) == limit && next > id {
+			next = id
+		}
+	}
+	return objects, next, total
 }
 
-// Len gives the total number of objects stored.
-func Len() int {
-	return len(storedObjects)
+// Delete removes the object with ID, returns false if there was no such object.
+func (repo *Repository) Delete(id int) bool {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	if id >= repo.offset && id < repo.offset+len(repo.entries) && repo.entries[id-repo.offset].object != nil {
+		repo.remove(id - repo.offset)
+		repo.stats.Deleted++
+		repo.compact()
+		return true
+	}
+	return false
 }
 
-func tomap(start, end int) map[int]interface{} {
+// DeleteFunc removes all objects for which match returns true, and returns their IDs.
+func (repo *Repository) DeleteFunc(match func(interface{}) bool) []int {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	ids := []int{}
+	for i, e := range repo.entries {
+		if e.object != nil && match(e.object) {
+			repo.remove(i)
+			repo.stats.Deleted++
+			ids = append(ids, repo.offset+i)
+		}
+	}
+	repo.compact()
+	return ids
+}
+
+// Reset removes all objects in storage and clears statistics, IDs of removed objects are not reused.
+func (repo *Repository) Reset() {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	repo.offset += len(repo.entries)
+	repo.entries = []entry{}
+	repo.stats = Stats{}
+}
+
+// Len gives the total number of IDs allocated, including those of deleted or evicted objects.
+func (repo *Repository) Len() int {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+	return repo.offset + len(repo.entries)
+}
+
+// Stats returns statistics of the repository.
+func (repo *Repository) Stats() Stats {
+	repo.expire()
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+	stats := repo.stats
+	stats.NextID = repo.offset + len(repo.entries)
+	return stats
+}
+
+func (repo *Repository) tomap(start, end int) map[int]interface{} {
 	m := make(map[int]interface{})
+	if start < repo.offset {
+		start = repo.offset
+	}
 	for i := start; i < end; i++ {
-		m[i] = storedObjects[i]
+		if o := repo.entries[i-repo.offset].object; o != nil {
+			m[i] = o
+		}
 	}
 	return m
 }
+
+// expire evicts objects older than the TTL.
+func (repo *Repository) expire() {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	repo.evict(time.Now())
+}
+
+// evict removes the oldest objects until the retention policy is respected, the caller must hold the lock.
+func (repo *Repository) evict(now time.Time) {
+	r := repo.retention
+	for i := 0; i < len(repo.entries); i++ {
+		e := repo.entries[i]
+		if e.object == nil {
+			continue
+		}
+		expired := r.TTL > 0 && now.Sub(e.stored) > r.TTL
+		tooMany := r.MaxCount > 0 && repo.stats.Count > r.MaxCount
+		tooBig := r.MaxBytes > 0 && repo.stats.Bytes > r.MaxBytes
+		if !expired && !tooMany && !tooBig {
+			break
+		}
+		repo.remove(i)
+		repo.stats.Evicted++
+	}
+	repo.compact()
+}
+
+// remove clears the entry at index i, the caller must hold the lock.
+func (repo *Repository) remove(i int) {
+	repo.stats.Count--
+	repo.stats.Bytes -= repo.entries[i].size
+	repo.entries[i] = entry{}
+}
+
+// compact releases leading removed entries, the caller must hold the lock.
+func (repo *Repository) compact() {
+	i := 0
+	for i < len(repo.entries) && repo.entries[i].object == nil {
+		i++
+	}
+	repo.entries = repo.entries[i:]
+	repo.offset += i
+}
@@ -2,6 +2,7 @@ package repository_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/adrienaury/mailmock/internal/repository"
 	"github.com/stretchr/testify/assert"
@@ -20,41 +21,202 @@ func TestRepositoryNil(t *testing.T) {
 }
 
 func TestRepositoryAll(t *testing.T) {
-	repository.Reset()
-	repository.Store("1")
-	repository.Store("2")
-	repository.Store("3")
-	repository.Store("4")
-	repository.Store("5")
-
-	len := repository.Len()
+	repo := repository.New()
+	repo.Store("1")
+	repo.Store("2")
+	repo.Store("3")
+	repo.Store("4")
+	repo.Store("5")
+
+	len := repo.Len()
 	assert.Equal(t, 5, len, "")
 
-	slice, full := repository.All(0, 2)
+	slice, full := repo.All(0, 2)
 	assert.Equal(t, map[int]interface{}{0: "1", 1: "2"}, slice, "")
 	assert.Equal(t, false, full, "")
 
-	slice, full = repository.All(0, 5)
+	slice, full = repo.All(0, 5)
 	assert.Equal(t, map[int]interface{}{0: "1", 1: "2", 2: "3", 3: "4", 4: "5"}, slice, "")
 	assert.Equal(t, true, full, "")
 
-	slice, full = repository.All(0, 10)
+	slice, full = repo.All(0, 10)
 	assert.Equal(t, map[int]interface{}{0: "1", 1: "2", 2: "3", 3: "4", 4: "5"}, slice, "")
 	assert.Equal(t, true, full, "")
 
-	slice, full = repository.All(2, 2)
+	slice, full = repo.All(2, 2)
 	assert.Equal(t, map[int]interface{}{2: "3", 3: "4"}, slice, "")
 	assert.Equal(t, false, full, "")
 
-	slice, full = repository.All(2, 5)
+	slice, full = repo.All(2, 5)
 	assert.Equal(t, map[int]interface{}{2: "3", 3: "4", 4: "5"}, slice, "")
 	assert.Equal(t, false, full, "")
 
-	slice, full = repository.All(5, 5)
+	slice, full = repo.All(5, 5)
 	assert.Equal(t, map[int]interface{}{}, slice, "")
 	assert.Equal(t, false, full, "")
 
-	slice, full = repository.All(10, 5)
+	slice, full = repo.All(10, 5)
 	assert.Nil(t, slice, "")
 	assert.Equal(t, false, full, "")
 }
+
+func TestRepositoryDelete(t *testing.T) {
+	repo := repository.New()
+	repo.Store("1")
+	repo.Store("2")
+	repo.Store("3")
+
+	assert.True(t, repo.Delete(1), "")
+	assert.False(t, repo.Delete(1), "")
+	assert.False(t, repo.Delete(9999), "")
+	assert.Nil(t, repo.Use(1), "")
+
+	slice, full := repo.All(0, 5)
+	assert.Equal(t, map[int]interface{}{0: "1", 2: "3"}, slice, "")
+	assert.Equal(t, true, full, "")
+
+	id := repo.Store("4")
+	assert.Equal(t, 3, id, "IDs of deleted objects MUST NOT be reused")
+	assert.Equal(t, 4, repo.Len(), "")
+}
+
+func TestRepositoryDeleteFunc(t *testing.T) {
+	repo := repository.New()
+	repo.Store("1")
+	repo.Store("2")
+	repo.Store("1")
+
+	ids := repo.DeleteFunc(func(o interface{}) bool { return o == "1" })
+	assert.Equal(t, []int{0, 2}, ids, "")
+
+	slice, _ := repo.All(0, 5)
+	assert.Equal(t, map[int]interface{}{1: "2"}, slice, "")
+}
+
+func TestRepositoryFilter(t *testing.T) {
+	repo := repository.New()
+	for _, o := range []string{"a1", "b2", "a3", "b4", "a5", "a6"} {
+		repo.Store(o)
+	}
+	repo.Delete(2)
+	match := func(o interface{}) bool { return o.(string)[0] == 'a' }
+
+	objects, next, total := repo.Filter(0, 2, match)
+	assert.Equal(t, map[int]interface{}{0: "a1", 4: "a5"}, objects, "Pages MUST be filled with matching objects")
+	assert.Equal(t, 5, next, "")
+	assert.Equal(t, 3, total, "Total MUST count matching objects only")
+
+	objects, next, total = repo.Filter(next, 2, match)
+	assert.Equal(t, map[int]interface{}{5: "a6"}, objects, "")
+	assert.Equal(t, 6, next, "")
+	assert.Equal(t, 3, total, "")
+
+	objects, next, _ = repo.Filter(next, 2, match)
+	assert.Empty(t, objects, "")
+	assert.Equal(t, 6, next, "")
+}
+
+func TestRepositoryNamespaces(t *testing.T) {
+	id := repository.Store("default")
+
+	assert.Nil(t, repository.Find("ns1"), "")
+
+	ns1 := repository.Namespace("ns1")
+	ns2 := repository.Namespace("ns2")
+	assert.Equal(t, ns1, repository.Find("ns1"), "")
+	assert.Equal(t, ns1, repository.Namespace("ns1"), "")
+	assert.Equal(t, []string{"ns1", "ns2"}, repository.Namespaces(), "")
+
+	assert.Equal(t, 0, ns1.Store("1"), "Each namespace MUST have its own ID space")
+	assert.Equal(t, 0, ns2.Store("2"), "Each namespace MUST have its own ID space")
+	assert.Equal(t, 1, ns2.Store("3"), "")
+
+	ns2.Reset()
+	assert.Equal(t, 0, ns2.Stats().Count, "")
+	assert.Equal(t, 2, ns2.Store("4"), "IDs MUST NOT be reused after a reset")
+	assert.Equal(t, 1, ns1.Len(), "Reset of a namespace MUST NOT affect other namespaces")
+	assert.Equal(t, "default", repository.Namespace("").Use(id), "")
+
+	repository.Remove("ns1")
+	repository.Remove("ns2")
+	repository.Remove("")
+	assert.Nil(t, repository.Find("ns1"), "Removed namespaces MUST NOT be found")
+	assert.Empty(t, repository.Namespaces(), "")
+	assert.Equal(t, "default", repository.Find("").Use(id), "The default namespace MUST NOT be removed")
+}
+
+type sized string
+
+func (s sized) Size() int {
+	return len(s)
+}
+
+func TestRepositoryRetentionCount(t *testing.T) {
+	repo := repository.New()
+	repo.SetRetention(repository.Retention{MaxCount: 2})
+	repo.Store("1")
+	repo.Store("2")
+	repo.Store("3")
+
+	assert.Nil(t, repo.Use(0), "Oldest objects MUST be evicted first")
+	assert.Equal(t, "3", repo.Use(2), "")
+
+	slice, full := repo.All(0, 2)
+	assert.Equal(t, map[int]interface{}{1: "2"}, slice, "IDs MUST NOT change after eviction")
+	assert.Equal(t, false, full, "")
+
+	slice, full = repo.All(0, 5)
+	assert.Equal(t, map[int]interface{}{1: "2", 2: "3"}, slice, "")
+	assert.Equal(t, true, full, "")
+
+	assert.Equal(t, repository.Stats{Count: 2, NextID: 3, Evicted: 1}, repo.Stats(), "")
+}
+
+func TestRepositoryRetentionBytes(t *testing.T) {
+	repo := repository.New()
+	repo.SetRetention(repository.Retention{MaxBytes: 10})
+	repo.Store(sized("12345"))
+	repo.Store(sized("12345"))
+	assert.Equal(t, repository.Stats{Count: 2, Bytes: 10, NextID: 2}, repo.Stats(), "")
+
+	repo.Store(sized("123"))
+	assert.Equal(t, repository.Stats{Count: 2, Bytes: 8, NextID: 3, Evicted: 1}, repo.Stats(), "")
+
+	assert.True(t, repo.Delete(1), "")
+	assert.Equal(t, repository.Stats{Count: 1, Bytes: 3, NextID: 3, Deleted: 1, Evicted: 1}, repo.Stats(), "")
+}
+
+func TestRepositoryRetentionTTL(t *testing.T) {
+	repo := repository.New()
+	repo.SetRetention(repository.Retention{TTL: 50 * time.Millisecond})
+	repo.Store("1")
+	time.Sleep(100 * time.Millisecond)
+	repo.Store("2")
+
+	slice, full := repo.All(0, 5)
+	assert.Equal(t, map[int]interface{}{1: "2"}, slice, "Expired objects MUST be evicted")
+	assert.Equal(t, true, full, "")
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, repo.Use(1), "Expired objects MUST be evicted")
+	assert.Equal(t, repository.Stats{NextID: 2, Evicted: 2}, repo.Stats(), "")
+	assert.Equal(t, 2, repo.Store("3"), "IDs of evicted objects MUST NOT be reused")
+}
+
+func TestRepositorySweep(t *testing.T) {
+	repository.SetRetention(repository.Retention{TTL: 10 * time.Millisecond})
+	t.Cleanup(func() { repository.SetRetention(repository.Retention{}) })
+	repository.Namespace("sweep").Store("expired")
+	repository.Namespace("kept").Store("kept")
+	repository.Namespace("kept").SetRetention(repository.Retention{})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go repository.Sweep(20*time.Millisecond, stop)
+
+	assert.Eventually(t, func() bool { return repository.Find("sweep") == nil }, 5*time.Second, 10*time.Millisecond,
+		"Namespaces left empty by the retention policy MUST be removed")
+	assert.NotNil(t, repository.Find("kept"), "Namespaces with objects MUST be kept")
+	assert.Equal(t, 1, repository.Find("kept").Stats().Count, "")
+	repository.Remove("kept")
+}
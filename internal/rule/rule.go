@@ -0,0 +1,257 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package rule rejects transactions at the end of DATA depending on their content, like spam or virus filters do.
+package rule
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+)
+
+// Rule is the configuration of a rule, a mail matches if it satisfies every condition given.
+type Rule struct {
+	Name        string   `mapstructure:"name"`
+	Header      string   `mapstructure:"header"`      // name of a header field whose value must match Match
+	Match       string   `mapstructure:"match"`       // regular expression
+	Body        []string `mapstructure:"body"`        // the text parts of the body must contain one of these keywords (case insensitive)
+	Attachments []string `mapstructure:"attachments"` // an attachment must have one of these content types or file extensions
+	MaxSize     int      `mapstructure:"maxSize"`     // the size of the mail in bytes must exceed this value
+	MissingDKIM bool     `mapstructure:"missingDKIM"` // the mail must have no DKIM-Signature header field
+	Reply       string   `mapstructure:"reply"`       // reply to DATA, "554 Transaction failed" if empty
+}
+
+// Rejection is the error returned when a rule matches, it wraps the reply sent to the client.
+type Rejection struct {
+	Rule  string
+	Reply *smtpd.Response
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("rule %v matched", r.Rule)
+}
+
+// Unwrap returns the reply sent to the client.
+func (r *Rejection) Unwrap() error {
+	return r.Reply
+}
+
+type rule struct {
+	Rule
+	match *regexp.Regexp
+	reply *smtpd.Response
+}
+
+// Rules is an ordered list of rules, the first matching rule rejects the transaction.
+type Rules struct {
+	rules []rule
+}
+
+// New validates the rules.
+func New(rules []Rule) (*Rules, error) {
+	rs := &Rules{rules: make([]rule, 0, len(rules))}
+	for i, r := range rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("#%v", i+1)
+		}
+		compiled := rule{Rule: r, reply: &smtpd.Response{Code: smtpd.CodeTransactionFailed, Msg: []string{"Transaction failed"}}}
+		if (r.Header == "") != (r.Match == "") {
+			return nil, fmt.Errorf("rule %v: header and match must be given together", r.Name)
+		}
+		if r.Match != "" {
+			re, err := regexp.Compile(r.Match)
+			if err != nil {
+				return nil, fmt.Errorf("rule %v: %w", r.Name, err)
+			}
+			compiled.match = re
+		}
+		if r.Reply != "" {
+			reply, err := smtpd.ParseReply(r.Reply)
+			if err == nil && reply.Code < 400 {
+				err = fmt.Errorf("invalid reply %q, code must be between 400 and 599", r.Reply)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("rule %v: %w", r.Name, err)
+			}
+			if len(reply.Msg) == 0 {
+				reply.Msg = compiled.reply.Msg
+			}
+			compiled.reply = reply
+		}
+		rs.rules = append(rs.rules, compiled)
+	}
+	return rs, nil
+}
+
+// Check returns a *Rejection if a rule matches the mail of the transaction.
+func (rs *Rules) Check(tr *smtpd.Transaction) error {
+	for _, r := range rs.rules {
+		if r.matches(tr.Mail) {
+			return &Rejection{r.Name, r.reply}
+		}
+	}
+	return nil
+}
+
+// Handler returns a handler rejecting transactions matched by a rule, to use in a smtpd.Chain.
+func (rs *Rules) Handler() smtpd.Handler {
+	return rs.Check
+}
+
+func (r rule) matches(m smtpd.Mail) bool {
+	if r.match != nil && !r.match.MatchString(m.Header(r.Header)) {
+		return false
+	}
+	if r.MaxSize > 0 && m.Size() <= r.MaxSize {
+		return false
+	}
+	if r.MissingDKIM && m.Header("DKIM-Signature") != "" {
+		return false
+	}
+	if len(r.Body) > 0 && !containsAny(strings.ToLower(body(m)), r.Body) {
+		return false
+	}
+	if len(r.Attachments) > 0 && !r.hasAttachment(m) {
+		return false
+	}
+	return true
+}
+
+func (r rule) hasAttachment(m smtpd.Mail) bool {
+	for _, a := range attachments(m) {
+		for _, kind := range r.Attachments {
+			kind = strings.ToLower(kind)
+			if a.contentType == kind || (strings.HasPrefix(kind, ".") && path.Ext(a.filename) == kind) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsAny(s string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if strings.Contains(s, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// body returns the text parts of the mail decoded from base64 or quoted-printable,
+// or the lines following the header if the mail can't be parsed.
+func body(m smtpd.Mail) string {
+	msg, err := mail.ReadMessage(strings.NewReader(strings.Join(m.Content, "\r\n") + "\r\n"))
+	if err != nil {
+		for i, line := range m.Content {
+			if line == "" {
+				return strings.Join(m.Content[i+1:], "\n")
+			}
+		}
+		return ""
+	}
+	var b strings.Builder
+	texts(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, &b)
+	return b.String()
+}
+
+// texts writes the decoded content of text parts to b, attachments are skipped.
+func texts(contentType string, encoding string, r io.Reader, b *strings.Builder) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain" // default content type (RFC 2045)
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				return
+			}
+			if disposition, _, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition")); disposition == "attachment" {
+				continue
+			}
+			// quoted-printable parts are decoded by the multipart reader
+			texts(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p, b)
+		}
+	}
+	if !strings.HasPrefix(mediaType, "text/") {
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+	content, _ := io.ReadAll(r)
+	b.Write(content)
+	b.WriteString("\n")
+}
+
+type attachment struct {
+	contentType string
+	filename    string
+}
+
+// attachments returns the parts of a multipart mail having a file name or an attachment disposition.
+func attachments(m smtpd.Mail) []attachment {
+	msg, err := mail.ReadMessage(strings.NewReader(strings.Join(m.Content, "\r\n") + "\r\n"))
+	if err != nil {
+		return nil
+	}
+	return parts(msg.Header.Get("Content-Type"), msg.Body)
+}
+
+func parts(contentType string, r io.Reader) []attachment {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil
+	}
+	result := []attachment{}
+	mr := multipart.NewReader(r, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			return result
+		}
+		partType := p.Header.Get("Content-Type")
+		if strings.HasPrefix(strings.ToLower(partType), "multipart/") {
+			result = append(result, parts(partType, p)...)
+			continue
+		}
+		disposition, _, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+		mediaType, params, _ := mime.ParseMediaType(partType)
+		filename := p.FileName()
+		if filename == "" {
+			filename = params["name"]
+		}
+		if disposition == "attachment" || filename != "" {
+			result = append(result, attachment{strings.ToLower(mediaType), strings.ToLower(filename)})
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package rule_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/adrienaury/mailmock/internal/rule"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func transaction(content ...string) *smtpd.Transaction {
+	return &smtpd.Transaction{Mail: smtpd.Mail{Content: content}, State: smtpd.TSCompleted}
+}
+
+func TestRules(t *testing.T) {
+	rules, err := rule.New([]rule.Rule{
+		{Name: "spam", Header: "Subject", Match: "(?i)viagra", Reply: "550 5.7.1 Message rejected as spam"},
+		{Name: "keyword", Body: []string{"LOTTERY"}},
+		{Name: "virus", Attachments: []string{".exe", "application/x-msdownload"}, Reply: "554 5.7.1 Virus found"},
+		{Name: "size", MaxSize: 100, Reply: "451 4.3.0 Try again later"},
+		{Name: "dkim", Header: "From", Match: "@bank\\.example$", MissingDKIM: true},
+	})
+	assert.NoError(t, err, "")
+
+	tests := []struct {
+		tr    *smtpd.Transaction
+		rule  string
+		reply string
+	}{
+		{transaction("Subject: Buy VIAGRA now", "", "body"), "spam", "550 5.7.1 Message rejected as spam"},
+		{transaction("Subject: hello", "", "You won the lottery"), "keyword", "554 Transaction failed"},
+		{transaction(
+			"Subject: invoice",
+			"Content-Type: multipart/mixed; boundary=b",
+			"",
+			"--b",
+			"Content-Type: text/plain",
+			"",
+			"see attached",
+			"--b",
+			"Content-Type: application/octet-stream",
+			"Content-Disposition: attachment; filename=\"Invoice.EXE\"",
+			"",
+			"TVqQAAMAAAAEAAAA",
+			"--b--",
+		), "virus", "554 5.7.1 Virus found"},
+		{transaction("Subject: hello", "", "0123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789"), "size", "451 4.3.0 Try again later"},
+		{transaction("From: support@bank.example", "", "body"), "dkim", "554 Transaction failed"},
+		{transaction("From: support@bank.example", "DKIM-Signature: v=1; d=bank.example", "", "body"), "", ""},
+		{transaction("Subject: hello", "", "body"), "", ""},
+	}
+	for _, test := range tests {
+		err := rules.Check(test.tr)
+		if test.rule == "" {
+			assert.NoError(t, err, "")
+			continue
+		}
+		var rejection *rule.Rejection
+		assert.True(t, errors.As(err, &rejection), test.rule)
+		assert.Equal(t, test.rule, rejection.Rule, "")
+		var reply *smtpd.Response
+		assert.True(t, errors.As(err, &reply), "A rejection MUST wrap the reply")
+		assert.Equal(t, test.reply, reply.String(), "")
+	}
+}
+
+func TestRulesBody(t *testing.T) {
+	rules, err := rule.New([]rule.Rule{{Name: "keyword", Body: []string{"LOTTERY"}}})
+	assert.NoError(t, err, "")
+
+	tests := []struct {
+		tr      *smtpd.Transaction
+		matches bool
+	}{
+		{transaction("Subject: hello", "Content-Transfer-Encoding: base64", "", "WW91IHdvbiB0aGUg", "bG90dGVyeQ=="), true},
+		{transaction(
+			"Subject: hello",
+			"Content-Type: multipart/alternative; boundary=b",
+			"",
+			"--b",
+			"Content-Type: text/html; charset=utf-8",
+			"Content-Transfer-Encoding: quoted-printable",
+			"",
+			"<p style=3D\"color: red\">You won the lot=",
+			"tery</p>",
+			"--b--",
+		), true},
+		{transaction(
+			"Subject: hello",
+			"Content-Type: multipart/mixed; boundary=b",
+			"",
+			"--b",
+			"Content-Type: text/plain",
+			"",
+			"see attached",
+			"--b",
+			"Content-Type: text/plain",
+			"Content-Disposition: attachment; filename=\"rules.txt\"",
+			"",
+			"lottery",
+			"--b--",
+		), false},
+	}
+	for _, test := range tests {
+		err := rules.Check(test.tr)
+		assert.Equal(t, test.matches, err != nil, "Keywords MUST be searched in decoded text parts, except attachments")
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	_, err := rule.New([]rule.Rule{{Header: "Subject"}})
+	assert.EqualError(t, err, "rule #1: header and match must be given together", "")
+	_, err = rule.New([]rule.Rule{{Name: "regexp", Header: "Subject", Match: "("}})
+	assert.Error(t, err, "")
+	_, err = rule.New([]rule.Rule{{Name: "reply", Reply: "250 OK"}})
+	assert.Error(t, err, "")
+}
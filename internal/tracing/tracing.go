@@ -0,0 +1,205 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package tracing records OpenTelemetry spans of SMTP sessions, commands, transactions and HTTP requests.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/adrienaury/mailmock"
+
+var (
+	propagator = propagation.TraceContext{}
+
+	// sessions holds the spans of sessions in progress, by session ID.
+	sessionsMutex sync.Mutex
+	sessions      = map[string]trace.Span{}
+)
+
+// Setup exports spans over OTLP/HTTP to the endpoint (e.g. http://localhost:4318), and returns a function
+// flushing and stopping the export. The OTEL_EXPORTER_OTLP_* environment variables are used if endpoint is empty,
+// tracing is disabled if none is set. Export errors are given to onError.
+func Setup(ctx context.Context, endpoint string, version string, onError func(error)) (func(context.Context) error, error) {
+	options := []otlptracehttp.Option{}
+	switch {
+	case endpoint != "":
+		options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+	case !configured():
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("mailmock"),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(onError))
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// configured returns true if an endpoint is given by environment variables.
+func configured() bool {
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"} {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// SessionEvent starts the span of a session when the client is connected, and ends it when the session is closed.
+func SessionEvent(s *smtpd.Session, e smtpd.SessionEvent) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	switch e {
+	case smtpd.SEConnected:
+		_, span := tracer().Start(context.Background(), "smtp.session",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithTimestamp(s.Start),
+			trace.WithAttributes(
+				attribute.String("smtp.session.id", s.ID),
+				attribute.String("net.peer.address", s.RemoteAddr),
+				attribute.String("net.host.address", s.LocalAddr),
+			))
+		sessions[s.ID] = span
+	case smtpd.SEHello:
+		if span, ok := sessions[s.ID]; ok {
+			span.SetAttributes(attribute.String("smtp.client", s.Client), attribute.Bool("smtp.extended", s.Extended))
+		}
+	case smtpd.SEClosed:
+		if span, ok := sessions[s.ID]; ok {
+			span.SetAttributes(attribute.String("smtp.close_reason", s.CloseReason))
+			if s.CloseReason != smtpd.CRQuit {
+				span.SetStatus(codes.Error, s.CloseReason)
+			}
+			span.End(trace.WithTimestamp(s.End))
+			delete(sessions, s.ID)
+		}
+	}
+}
+
+// sessionContext returns a context holding the span of the session, if it is in progress.
+func sessionContext(id string) context.Context {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	if span, ok := sessions[id]; ok {
+		return trace.ContextWithSpan(context.Background(), span)
+	}
+	return context.Background()
+}
+
+// Command records the span of a processed command, as a child of the span of the session.
+// Commands unknown by the server are named smtp.unknown, so that clients can't create span names.
+func Command(s *smtpd.Session, name string, res *smtpd.Response, duration time.Duration) {
+	if !smtpd.IsCommand(name) {
+		name = "UNKNOWN"
+	}
+	end := time.Now()
+	_, span := tracer().Start(sessionContext(s.ID), "smtp."+strings.ToLower(name),
+		trace.WithTimestamp(end.Add(-duration)),
+		trace.WithAttributes(
+			attribute.String("smtp.command", name),
+			attribute.Int("smtp.reply.code", int(res.Code)),
+		))
+	if res.Code >= 400 {
+		span.SetStatus(codes.Error, res.String())
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// Transaction records the span of a transaction, as a child of the span of the session.
+// If the mail has a W3C traceparent header, the span is linked to the span of the sender.
+func Transaction(tr *smtpd.Transaction) {
+	start := tr.Received
+	if len(tr.History) > 0 {
+		start = tr.History[0].Time
+	}
+	options := []trace.SpanStartOption{
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			attribute.String("smtp.session.id", tr.Session),
+			attribute.String("smtp.transaction.state", string(tr.State)),
+			attribute.String("smtp.mail.from", tr.Mail.Envelope.Sender),
+			attribute.StringSlice("smtp.rcpt.to", tr.Mail.Envelope.Recipients),
+			attribute.Int("smtp.mail.size", tr.Size()),
+		),
+	}
+	carrier := propagation.MapCarrier{
+		"traceparent": tr.Mail.Header("traceparent"),
+		"tracestate":  tr.Mail.Header("tracestate"),
+	}
+	if sender := trace.SpanContextFromContext(propagator.Extract(context.Background(), carrier)); sender.IsValid() {
+		options = append(options, trace.WithLinks(trace.Link{SpanContext: sender}))
+	}
+	_, span := tracer().Start(sessionContext(tr.Session), "smtp.transaction", options...)
+	if tr.State != smtpd.TSCompleted {
+		span.SetStatus(codes.Error, string(tr.State))
+	}
+	span.End(trace.WithTimestamp(tr.Received))
+}
+
+// Request starts the span of a HTTP request, continuing the trace of the caller if any.
+// The returned function ends the span, with the route matched and the status code.
+func Request(r *http.Request) (*http.Request, func(route string, status int)) {
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer().Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			attribute.String("client.address", r.RemoteAddr),
+		))
+	return r.WithContext(ctx), func(route string, status int) {
+		if route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		span.End()
+	}
+}
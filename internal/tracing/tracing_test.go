@@ -0,0 +1,94 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/tracing"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+	collector "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collectorStub is an OTLP/HTTP collector keeping received spans by name.
+type collectorStub struct {
+	mutex sync.Mutex
+	spans map[string]*tracepb.Span
+}
+
+func (c *collectorStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	req := &collector.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mutex.Lock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				c.spans[span.Name] = span
+			}
+		}
+	}
+	c.mutex.Unlock()
+	res, _ := proto.Marshal(&collector.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(res)
+}
+
+func TestTracing(t *testing.T) {
+	stub := &collectorStub{spans: map[string]*tracepb.Span{}}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	shutdown, err := tracing.Setup(context.Background(), srv.URL, "test", func(err error) { assert.NoError(t, err) })
+	assert.NoError(t, err)
+
+	s := &smtpd.Session{ID: "s1", Start: time.Now(), RemoteAddr: "127.0.0.1:40000"}
+	tracing.SessionEvent(s, smtpd.SEConnected)
+	tracing.Command(s, "MAIL", &smtpd.Response{Code: smtpd.CodeSuccess}, time.Millisecond)
+	tracing.Command(s, "GARBAGE", &smtpd.Response{Code: smtpd.CodeCommandUnrecognized}, time.Millisecond)
+	tr := smtpd.NewTransaction()
+	tr.Session = "s1"
+	tr.State = smtpd.TSCompleted
+	tr.Received = time.Now()
+	tr.Mail.Content = []string{"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", "body"}
+	tracing.Transaction(tr)
+	s.End, s.CloseReason = time.Now(), smtpd.CRQuit
+	tracing.SessionEvent(s, smtpd.SEClosed)
+
+	r, end := tracing.Request(httptest.NewRequest("GET", "/v1/api/mailmock/1", nil))
+	assert.NotNil(t, r)
+	end("/v1/api/mailmock/{ID}", http.StatusOK)
+
+	assert.NoError(t, shutdown(context.Background()))
+
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	session, command, transaction := stub.spans["smtp.session"], stub.spans["smtp.mail"], stub.spans["smtp.transaction"]
+	assert.NotNil(t, session, "Session span MUST be exported")
+	assert.NotNil(t, command, "Command span MUST be exported")
+	assert.NotNil(t, transaction, "Transaction span MUST be exported")
+	assert.NotNil(t, stub.spans["GET /v1/api/mailmock/{ID}"], "Request span MUST be named after the route")
+	assert.NotNil(t, stub.spans["smtp.unknown"], "Unknown commands MUST NOT be used as span name")
+	assert.Nil(t, stub.spans["smtp.garbage"], "Unknown commands MUST NOT be used as span name")
+	if session == nil || command == nil || transaction == nil {
+		return
+	}
+	assert.Equal(t, session.SpanId, command.ParentSpanId, "Command span MUST be a child of the session span")
+	assert.Equal(t, session.SpanId, transaction.ParentSpanId, "Transaction span MUST be a child of the session span")
+	assert.Len(t, transaction.Links, 1, "Transaction span MUST be linked to the traceparent of the mail")
+	if len(transaction.Links) == 1 {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(transaction.Links[0].TraceId), "")
+		assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(transaction.Links[0].SpanId), "")
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package webhook notifies HTTP endpoints of completed transactions.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+)
+
+// Headers of notifications.
+const (
+	HeaderSignature = "X-Mailmock-Signature" // "sha256=" followed by the HMAC of the body, if a secret is set
+	HeaderDelivery  = "X-Mailmock-Delivery"  // ID of the delivery, the same for each attempt
+)
+
+// Config is the configuration of a webhook.
+type Config struct {
+	URL        string   `mapstructure:"url"`
+	Secret     string   `mapstructure:"secret"`     // key used to sign payloads, no signature if empty
+	Recipients []string `mapstructure:"recipients"` // notify only if a recipient address contains one of these values
+	Domains    []string `mapstructure:"domains"`    // notify only if a recipient address belongs to one of these domains
+}
+
+// match returns true if the transaction is selected by the filters of the webhook.
+func (c Config) match(tr *smtpd.Transaction) bool {
+	if len(c.Recipients) == 0 && len(c.Domains) == 0 {
+		return true
+	}
+	for _, rcpt := range tr.Mail.Envelope.Recipients {
+		addr := strings.ToLower(strings.Trim(rcpt, "<>"))
+		for _, part := range c.Recipients {
+			if strings.Contains(addr, strings.ToLower(part)) {
+				return true
+			}
+		}
+		for _, domain := range c.Domains {
+			if strings.HasSuffix(addr, "@"+strings.ToLower(domain)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Metadata are parsed from the header of the mail.
+type Metadata struct {
+	Subject   string `json:"subject"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Cc        string `json:"cc"`
+	MessageID string `json:"messageID"`
+	Date      string `json:"date"`
+	Size      int    `json:"size"`
+}
+
+// Payload is the JSON body posted to webhooks.
+type Payload struct {
+	Event       string             `json:"event"`
+	IDs         map[string]int     `json:"ids"` // IDs of the transaction, by namespace (empty name for the default namespace)
+	Transaction *smtpd.Transaction `json:"transaction"`
+	Metadata    Metadata           `json:"metadata"`
+}
+
+// Delivery states
+const (
+	DSPending   = "pending"   // delivery is in progress or will be retried
+	DSDelivered = "delivered" // webhook replied with a 2xx status
+	DSFailed    = "failed"    // all attempts failed
+)
+
+// Attempt is an attempt of delivery.
+type Attempt struct {
+	Time       time.Time     `json:"time"`
+	StatusCode int           `json:"statusCode"` // 0 if no response was received
+	Error      string        `json:"error"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Delivery is the notification of a transaction to a webhook.
+type Delivery struct {
+	mutex    sync.RWMutex
+	ID       int            `json:"id"`
+	URL      string         `json:"url"`
+	Session  string         `json:"session"` // ID of the session of the transaction
+	IDs      map[string]int `json:"ids"`
+	State    string         `json:"state"`
+	Attempts []Attempt      `json:"attempts"`
+}
+
+// MarshalJSON encodes the delivery while it can be updated by retries.
+func (d *Delivery) MarshalJSON() ([]byte, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	type delivery Delivery
+	return json.Marshal(&struct {
+		*delivery
+		Attempts []Attempt `json:"attempts"`
+	}{(*delivery)(d), append([]Attempt{}, d.Attempts...)})
+}
+
+// Notifier posts transactions to webhooks.
+type Notifier struct {
+	hooks    []Config
+	client   *http.Client
+	attempts int           // maximum number of attempts of each delivery
+	backoff  time.Duration // delay before the first retry, doubled for each retry
+	logger   log.Logger
+	wg       sync.WaitGroup
+}
+
+// New creates a notifier posting to the given webhooks, with 5 attempts starting after 1 second.
+func New(hooks []Config, logger log.Logger) *Notifier {
+	if logger == nil {
+		logger = log.DefaultLogger
+	}
+	return &Notifier{
+		hooks:    hooks,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		attempts: 5,
+		backoff:  time.Second,
+		logger:   logger,
+	}
+}
+
+// SetRetries sets the maximum number of attempts of each delivery, and the delay before the first retry.
+func (n *Notifier) SetRetries(attempts int, backoff time.Duration) {
+	n.attempts = attempts
+	n.backoff = backoff
+}
+
+// Notify posts a completed transaction to the webhooks selecting it, in background.
+// IDs of the transaction are given by namespace.
+func (n *Notifier) Notify(tr *smtpd.Transaction, ids map[string]int) {
+	if tr.State != smtpd.TSCompleted || len(n.hooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(Payload{
+		Event:       "transaction",
+		IDs:         ids,
+		Transaction: tr,
+		Metadata: Metadata{
+			Subject:   tr.Mail.Header("Subject"),
+			From:      tr.Mail.Header("From"),
+			To:        tr.Mail.Header("To"),
+			Cc:        tr.Mail.Header("Cc"),
+			MessageID: tr.Mail.Header("Message-ID"),
+			Date:      tr.Mail.Header("Date"),
+			Size:      tr.Size(),
+		},
+	})
+	if err != nil {
+		n.logger.Error("Failed to encode webhook payload", log.Fields{log.FieldError: err})
+		return
+	}
+	for _, hook := range n.hooks {
+		if !hook.match(tr) {
+			continue
+		}
+		d := &Delivery{URL: hook.URL, Session: tr.Session, IDs: ids, State: DSPending, Attempts: []Attempt{}}
+		d.mutex.Lock() // the delivery can be read as soon as it is stored
+		d.ID = repository.Deliveries().Store(d)
+		d.mutex.Unlock()
+		n.wg.Add(1)
+		go n.deliver(hook, d, body)
+	}
+}
+
+// Wait waits for deliveries in progress to be delivered or failed, at most for the given duration.
+// It returns false if some deliveries are still in progress.
+func (n *Notifier) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// deliver posts the body until the webhook accepts it or the maximum number of attempts is reached.
+func (n *Notifier) deliver(hook Config, d *Delivery, body []byte) {
+	defer n.wg.Done()
+	backoff := n.backoff
+	for i := 1; ; i++ {
+		attempt := n.post(hook, d.ID, body)
+		d.mutex.Lock()
+		d.Attempts = append(d.Attempts, attempt)
+		switch {
+		case attempt.Error == "":
+			d.State = DSDelivered
+		case i >= n.attempts:
+			d.State = DSFailed
+		}
+		state := d.State
+		d.mutex.Unlock()
+
+		switch state {
+		case DSDelivered:
+			n.logger.Debug("Webhook delivered", log.Fields{"url": hook.URL, "delivery": d.ID})
+			return
+		case DSFailed:
+			n.logger.Warn("Webhook delivery failed", log.Fields{"url": hook.URL, "delivery": d.ID, log.FieldError: attempt.Error})
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(hook Config, id int, body []byte) (attempt Attempt) {
+	attempt.Time = time.Now()
+	defer func() { attempt.Duration = time.Since(attempt.Time) }()
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, fmt.Sprint(id))
+	if hook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(hook.Secret, body))
+	}
+	res, err := n.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	res.Body.Close()
+	attempt.StatusCode = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		attempt.Error = res.Status
+	}
+	return attempt
+}
+
+// Sign returns the signature of the body with the secret, as sent in the X-Mailmock-Signature header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,134 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/internal/webhook"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver is a webhook endpoint failing the first requests.
+type receiver struct {
+	mutex    sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rcv *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.mutex.Lock()
+	defer rcv.mutex.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	rcv.requests = append(rcv.requests, r)
+	rcv.bodies = append(rcv.bodies, body)
+	if rcv.failures > 0 {
+		rcv.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func transaction(recipients ...string) *smtpd.Transaction {
+	tr := smtpd.NewTransaction()
+	tr.State = smtpd.TSCompleted
+	tr.Session = "s1"
+	tr.Mail = smtpd.Mail{
+		Envelope: smtpd.Envelope{Sender: "<alice@example.org>", Recipients: recipients},
+		Content:  []string{"Subject: Hello", "Message-ID: <1@example.org>", "", "body"},
+	}
+	return tr
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	repository.Deliveries().Reset()
+	id := repository.Deliveries().Len()
+	rcv := &receiver{failures: 1}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	n := webhook.New([]webhook.Config{{URL: srv.URL, Secret: "secret"}}, log.LoggerNoop{})
+	n.SetRetries(3, 10*time.Millisecond)
+	n.Notify(transaction("<bob@example.com>"), map[string]int{"": 4})
+	assert.True(t, n.Wait(10*time.Second), "")
+
+	assert.Len(t, rcv.requests, 2, "Delivery MUST be retried after a failure")
+	assert.Equal(t, webhook.Sign("secret", rcv.bodies[1]), rcv.requests[1].Header.Get(webhook.HeaderSignature), "")
+	assert.Equal(t, strconv.Itoa(id), rcv.requests[1].Header.Get(webhook.HeaderDelivery), "")
+
+	payload := webhook.Payload{}
+	assert.NoError(t, json.Unmarshal(rcv.bodies[1], &payload))
+	assert.Equal(t, map[string]int{"": 4}, payload.IDs, "")
+	assert.Equal(t, "Hello", payload.Metadata.Subject, "")
+	assert.Equal(t, "<1@example.org>", payload.Metadata.MessageID, "")
+	assert.Equal(t, "<alice@example.org>", payload.Transaction.Mail.Envelope.Sender, "")
+
+	d := repository.Deliveries().Use(id).(*webhook.Delivery)
+	assert.Equal(t, webhook.DSDelivered, d.State, "")
+	assert.Len(t, d.Attempts, 2, "")
+	assert.Equal(t, http.StatusInternalServerError, d.Attempts[0].StatusCode, "")
+}
+
+func TestWebhookFailure(t *testing.T) {
+	repository.Deliveries().Reset()
+	id := repository.Deliveries().Len()
+	rcv := &receiver{failures: 5}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	n := webhook.New([]webhook.Config{{URL: srv.URL}}, log.LoggerNoop{})
+	n.SetRetries(2, time.Millisecond)
+	n.Notify(transaction("<bob@example.com>"), nil)
+	assert.True(t, n.Wait(10*time.Second), "")
+
+	assert.Len(t, rcv.requests, 2, "Delivery MUST NOT be attempted more than the maximum")
+	assert.Empty(t, rcv.requests[0].Header.Get(webhook.HeaderSignature), "Payload MUST NOT be signed without secret")
+	d := repository.Deliveries().Use(id).(*webhook.Delivery)
+	assert.Equal(t, webhook.DSFailed, d.State, "")
+}
+
+func TestWebhookFilters(t *testing.T) {
+	repository.Deliveries().Reset()
+	rcv := &receiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	n := webhook.New([]webhook.Config{
+		{URL: srv.URL + "/domain", Domains: []string{"example.com"}},
+		{URL: srv.URL + "/recipient", Recipients: []string{"carol"}},
+	}, log.LoggerNoop{})
+	n.Notify(transaction("<bob@example.com>"), nil)
+	n.Notify(transaction("<carol@example.net>"), nil)
+	n.Notify(transaction("<dave@sub.example.com>"), nil)
+	aborted := transaction("<bob@example.com>")
+	aborted.State = smtpd.TSAborted
+	n.Notify(aborted, nil)
+	assert.True(t, n.Wait(10*time.Second), "")
+
+	paths := []string{}
+	for _, r := range rcv.requests {
+		paths = append(paths, r.URL.Path)
+	}
+	assert.ElementsMatch(t, []string{"/domain", "/recipient"}, paths, "")
+}
+
+func TestWebhookWaitTimeout(t *testing.T) {
+	repository.Deliveries().Reset()
+	rcv := &receiver{failures: 5}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	n := webhook.New([]webhook.Config{{URL: srv.URL}}, log.LoggerNoop{})
+	n.SetRetries(2, time.Second)
+	n.Notify(transaction("<bob@example.com>"), nil)
+	assert.False(t, n.Wait(100*time.Millisecond), "Wait MUST return when the timeout expires")
+	assert.True(t, n.Wait(10*time.Second), "")
+}
@@ -0,0 +1,307 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Linking this library statically or dynamically with other modules is
+// making a combined work based on this library.  Thus, the terms and
+// conditions of the GNU General Public License cover the whole
+// combination.
+//
+// As a special exception, the copyright holders of this library give you
+// permission to link this library with independent modules to produce an
+// executable, regardless of the license terms of these independent
+// modules, and to copy and distribute the resulting executable under
+// terms of your choice, provided that you also meet, for each linked
+// independent module, the terms and conditions of the license of that
+// module.  An independent module is a module which is not derived from
+// or based on this library.  If you modify this library, you may extend
+// this exception to your version of the library, but you are not
+// obligated to do so.  If you do not wish to do so, delete this
+// exception statement from your version.
+
+// Package client is a Go client of the Mailmock REST API.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+)
+
+// PageSize is the maximum number of transactions requested by page, it is the maximum allowed by the server.
+const PageSize = 50
+
+// ErrNotFound is returned when the requested transaction doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// Error is returned when the server replies with an unexpected status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("mailmock: %v %v", e.StatusCode, e.Message)
+}
+
+// Filter selects transactions, an empty filter selects everything.
+type Filter struct {
+	Sender    string                 // part of the sender address
+	Recipient string                 // part of one of the recipients address
+	State     smtpd.TransactionState // state of the transaction
+}
+
+func (f Filter) values() url.Values {
+	v := url.Values{}
+	if f.Sender != "" {
+		v.Set("sender", f.Sender)
+	}
+	if f.Recipient != "" {
+		v.Set("recipient", f.Recipient)
+	}
+	if f.State != "" {
+		v.Set("state", string(f.State))
+	}
+	return v
+}
+
+// Range is the range of IDs of a page, as given by the Content-Range header.
+type Range struct {
+	From  int // first ID of the page
+	To    int // first ID of the next page
+	Total int // number of transactions selected by the filter
+}
+
+// Page is a page of transactions, indexed by ID.
+type Page struct {
+	Transactions map[int]*smtpd.Transaction
+	Range        Range
+	Last         bool // true if there is no next page
+}
+
+// Client is a client of the Mailmock REST API.
+type Client struct {
+	baseURL      string
+	namespace    string
+	httpClient   *http.Client
+	auth         func(*http.Request)
+	pollInterval time.Duration
+}
+
+// New creates a client of the Mailmock instance at baseURL (e.g. http://localhost:8080).
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		httpClient:   http.DefaultClient,
+		pollInterval: 200 * time.Millisecond,
+	}
+}
+
+// SetHTTPClient sets the HTTP client used to send requests, http.DefaultClient is used by default.
+func (c *Client) SetHTTPClient(httpClient *http.Client) {
+	c.httpClient = httpClient
+}
+
+// SetBasicAuth sets the credentials sent with each request, e.g. to go through a reverse proxy.
+func (c *Client) SetBasicAuth(username, password string) {
+	c.auth = func(req *http.Request) { req.SetBasicAuth(username, password) }
+}
+
+// SetToken sets the bearer token sent with each request, e.g. to go through a reverse proxy.
+func (c *Client) SetToken(token string) {
+	c.auth = func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+}
+
+// SetPollInterval sets the interval between two requests of Wait.
+func (c *Client) SetPollInterval(interval time.Duration) {
+	c.pollInterval = interval
+}
+
+// Namespace returns a copy of the client scoped to the given namespace.
+func (c *Client) Namespace(name string) *Client {
+	scoped := *c
+	scoped.namespace = name
+	return &scoped
+}
+
+// List returns the page of transactions starting at ID from, with at most limit transactions selected by the filter.
+func (c *Client) List(ctx context.Context, from, limit int, f Filter) (*Page, error) {
+	query := f.values()
+	query.Set("from", strconv.Itoa(from))
+	query.Set("limit", strconv.Itoa(limit))
+
+	page := &Page{Transactions: map[int]*smtpd.Transaction{}}
+	res, err := c.do(ctx, http.MethodGet, "?"+query.Encode(), &page.Transactions)
+	if err != nil {
+		return nil, err
+	}
+	page.Range, err = parseRange(res.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	page.Last = res.StatusCode == http.StatusOK
+	return page, nil
+}
+
+// Search returns all transactions selected by the filter, following pages until the last one.
+func (c *Client) Search(ctx context.Context, f Filter) (map[int]*smtpd.Transaction, error) {
+	result := map[int]*smtpd.Transaction{}
+	for from := 0; ; {
+		page, err := c.List(ctx, from, PageSize, f)
+		if err != nil {
+			return nil, err
+		}
+		for id, tr := range page.Transactions {
+			result[id] = tr
+		}
+		if page.Last || len(page.Transactions) == 0 || len(result) >= page.Range.Total {
+			return result, nil
+		}
+		from = page.Range.To
+	}
+}
+
+// All returns all stored transactions.
+func (c *Client) All(ctx context.Context) (map[int]*smtpd.Transaction, error) {
+	return c.Search(ctx, Filter{})
+}
+
+// Get returns the transaction with the given ID, or ErrNotFound.
+func (c *Client) Get(ctx context.Context, id int) (*smtpd.Transaction, error) {
+	tr := &smtpd.Transaction{}
+	if _, err := c.do(ctx, http.MethodGet, "/"+strconv.Itoa(id), tr); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// Delete removes the transaction with the given ID, or returns ErrNotFound.
+func (c *Client) Delete(ctx context.Context, id int) error {
+	_, err := c.do(ctx, http.MethodDelete, "/"+strconv.Itoa(id), nil)
+	return err
+}
+
+// DeleteAll removes the transactions selected by the filter and returns their IDs.
+// With an empty filter, all transactions are purged and nil is returned.
+func (c *Client) DeleteAll(ctx context.Context, f Filter) ([]int, error) {
+	if f == (Filter{}) {
+		_, err := c.do(ctx, http.MethodDelete, "", nil)
+		return nil, err
+	}
+	result := struct {
+		Deleted []int `json:"deleted"`
+	}{}
+	if _, err := c.do(ctx, http.MethodDelete, "?"+f.values().Encode(), &result); err != nil {
+		return nil, err
+	}
+	return result.Deleted, nil
+}
+
+// Wait polls the server until a transaction selected by the filter is stored, and returns the one with the lowest ID.
+// It returns the error of the context if it is done first.
+func (c *Client) Wait(ctx context.Context, f Filter) (int, *smtpd.Transaction, error) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	for {
+		trs, err := c.Search(ctx, f)
+		if ctx.Err() != nil {
+			return 0, nil, ctx.Err()
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(trs) > 0 {
+			first := -1
+			for id := range trs {
+				if first < 0 || id < first {
+					first = id
+				}
+			}
+			return first, trs[first], nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Export writes the transactions selected by the filter to w, in mbox format and in order of ID.
+func (c *Client) Export(ctx context.Context, w io.Writer, f Filter) error {
+	trs, err := c.Search(ctx, f)
+	if err != nil {
+		return err
+	}
+	return WriteMbox(w, trs)
+}
+
+// path returns the URL of the transactions collection.
+func (c *Client) path() string {
+	if c.namespace != "" {
+		return c.baseURL + "/v1/namespaces/" + url.PathEscape(c.namespace) + "/mailmock"
+	}
+	return c.baseURL + "/v1/api/mailmock"
+}
+
+// do sends a request to the transactions collection and decodes the JSON response into v, if not nil.
+func (c *Client) do(ctx context.Context, method, path string, v interface{}) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.path()+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.auth != nil {
+		c.auth(req)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case res.StatusCode >= 300:
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, &Error{res.StatusCode, strings.TrimSpace(string(body))}
+	case v != nil && res.StatusCode != http.StatusNoContent:
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			return nil, fmt.Errorf("mailmock: invalid response: %v", err)
+		}
+	}
+	return res, nil
+}
+
+// parseRange parses a Content-Range header value such as "0-20/42".
+func parseRange(s string) (Range, error) {
+	var r Range
+	if _, err := fmt.Sscanf(s, "%d-%d/%d", &r.From, &r.To, &r.Total); err != nil {
+		return r, fmt.Errorf("mailmock: invalid Content-Range %q: %v", s, err)
+	}
+	return r, nil
+}
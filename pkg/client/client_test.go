@@ -0,0 +1,170 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/httpd"
+	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/pkg/client"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(t *testing.T) *client.Client {
+	repository.Reset()
+	srv := httptest.NewServer(httpd.NewServer("test", "", "0", nil).Routes())
+	t.Cleanup(srv.Close)
+	return client.New(srv.URL)
+}
+
+func store(repo *repository.Repository, sender string, recipients ...string) int {
+	tr := smtpd.NewTransaction()
+	tr.State = smtpd.TSCompleted
+	tr.Received = time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	tr.Mail = smtpd.Mail{
+		Envelope: smtpd.Envelope{Sender: sender, Recipients: recipients},
+		Content:  []string{"Subject: test", "", "From here", "Bye"},
+	}
+	return repo.Store(tr)
+}
+
+func TestClientSearch(t *testing.T) {
+	c := serve(t)
+	base := repository.Len()
+	carol := []int{}
+	for i := 0; i < 120; i++ {
+		if i%10 == 9 {
+			carol = append(carol, store(repository.Namespace(""), "carol@example.com", "bob@example.com"))
+		} else {
+			store(repository.Namespace(""), "alice@example.com", "bob@example.com")
+		}
+	}
+
+	page, err := c.List(context.Background(), base, 20, client.Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, page.Transactions, 20, "")
+	assert.Equal(t, client.Range{From: base, To: base + 20, Total: 120}, page.Range, "")
+	assert.False(t, page.Last, "")
+
+	page, err = c.List(context.Background(), 0, 5, client.Filter{Sender: "carol"})
+	assert.NoError(t, err)
+	assert.Len(t, page.Transactions, 5, "Pages MUST be filled with selected transactions")
+	assert.Equal(t, client.Range{From: 0, To: carol[5], Total: 12}, page.Range, "Total MUST count selected transactions")
+	assert.False(t, page.Last, "")
+
+	all, err := c.All(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, all, 120, "Search MUST follow all pages")
+
+	trs, err := c.Search(context.Background(), client.Filter{Sender: "carol"})
+	assert.NoError(t, err)
+	assert.Len(t, trs, 12, "")
+	assert.Equal(t, "carol@example.com", trs[carol[11]].Mail.Envelope.Sender, "")
+}
+
+func TestClientGetDelete(t *testing.T) {
+	c := serve(t)
+	id := store(repository.Namespace(""), "alice@example.com", "bob@example.com")
+	other := store(repository.Namespace(""), "carol@example.com", "dave@example.com")
+
+	tr, err := c.Get(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, smtpd.TSCompleted, tr.State, "")
+	assert.Equal(t, "test", tr.Mail.Header("Subject"), "")
+
+	_, err = c.Get(context.Background(), other+1)
+	assert.Equal(t, client.ErrNotFound, err, "")
+
+	assert.NoError(t, c.Delete(context.Background(), id))
+	assert.Equal(t, client.ErrNotFound, c.Delete(context.Background(), id), "")
+
+	ids, err := c.DeleteAll(context.Background(), client.Filter{Recipient: "dave"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{other}, ids, "")
+}
+
+func TestClientWait(t *testing.T) {
+	c := serve(t)
+	c.SetPollInterval(10 * time.Millisecond)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		store(repository.Namespace(""), "alice@example.com", "bob@example.com")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, tr, err := c.Wait(ctx, client.Filter{Recipient: "bob"})
+	assert.NoError(t, err)
+	assert.Equal(t, repository.Len()-1, id, "")
+	assert.Equal(t, "alice@example.com", tr.Mail.Envelope.Sender, "")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = c.Wait(ctx, client.Filter{Recipient: "nobody"})
+	assert.Equal(t, context.DeadlineExceeded, err, "")
+}
+
+func TestClientSearchNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("from") != "0" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Range", "0-50/100")
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte(`{"0":{}}`))
+	}))
+	defer srv.Close()
+
+	_, err := client.New(srv.URL).All(context.Background())
+	assert.Equal(t, client.ErrNotFound, err, "Not found pages MUST NOT end the search silently")
+}
+
+func TestClientNamespace(t *testing.T) {
+	c := serve(t)
+	repository.Namespace("suite1").Reset()
+	store(repository.Namespace("suite1"), "alice@example.com", "bob@example.com")
+
+	trs, err := c.Namespace("suite1").All(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, trs, 1, "")
+
+	trs, err = c.All(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, trs, 0, "Default namespace MUST NOT contain transactions of other namespaces")
+}
+
+func TestClientAuth(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL)
+	c.SetToken("secret")
+	_, err := c.Get(context.Background(), 0)
+	assert.Equal(t, "Bearer secret", auth, "")
+	assert.Equal(t, &client.Error{StatusCode: http.StatusForbidden, Message: "Forbidden"}, err, "")
+}
+
+func TestClientExport(t *testing.T) {
+	c := serve(t)
+	store(repository.Namespace(""), "alice@example.com", "bob@example.com")
+	store(repository.Namespace(""), "<>", "bob@example.com")
+
+	var b bytes.Buffer
+	assert.NoError(t, c.Export(context.Background(), &b, client.Filter{}))
+	assert.Equal(t, "From alice@example.com Tue Oct  1 12:00:00 2019\nSubject: test\n\n>From here\nBye\n\n"+
+		"From MAILER-DAEMON Tue Oct  1 12:00:00 2019\nSubject: test\n\n>From here\nBye\n\n", b.String(), "")
+
+	b.Reset()
+	assert.NoError(t, client.WriteEML(&b, smtpd.Mail{Content: []string{"Subject: test", "", "From here"}}))
+	assert.Equal(t, "Subject: test\r\n\r\nFrom here\r\n", b.String(), "")
+}
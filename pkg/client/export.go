@@ -0,0 +1,94 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Linking this library statically or dynamically with other modules is
+// making a combined work based on this library.  Thus, the terms and
+// conditions of the GNU General Public License cover the whole
+// combination.
+//
+// As a special exception, the copyright holders of this library give you
+// permission to link this library with independent modules to produce an
+// executable, regardless of the license terms of these independent
+// modules, and to copy and distribute the resulting executable under
+// terms of your choice, provided that you also meet, for each linked
+// independent module, the terms and conditions of the license of that
+// module.  An independent module is a module which is not derived from
+// or based on this library.  If you modify this library, you may extend
+// this exception to your version of the library, but you are not
+// obligated to do so.  If you do not wish to do so, delete this
+// exception statement from your version.
+
+package client
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+)
+
+// fromLine matches content lines that must be quoted in mbox format (mboxrd variant).
+var fromLine = regexp.MustCompile(`^>*From `)
+
+// WriteEML writes the content of the mail to w, as a RFC 5322 message with CRLF line endings.
+func WriteEML(w io.Writer, mail smtpd.Mail) error {
+	bw := bufio.NewWriter(w)
+	for _, line := range mail.Content {
+		if _, err := bw.WriteString(line + "\r\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteMbox writes the mails of the transactions to w in mbox format (mboxrd variant), in order of ID.
+// Each message is preceded by a "From " line with the envelope sender and the time of reception.
+func WriteMbox(w io.Writer, trs map[int]*smtpd.Transaction) error {
+	ids := make([]int, 0, len(trs))
+	for id := range trs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	bw := bufio.NewWriter(w)
+	for _, id := range ids {
+		tr := trs[id]
+		sender := strings.Trim(tr.Mail.Envelope.Sender, "<>")
+		if sender == "" {
+			sender = "MAILER-DAEMON" // null reverse-path
+		}
+		if _, err := bw.WriteString("From " + sender + " " + tr.Received.UTC().Format(time.ANSIC) + "\n"); err != nil {
+			return err
+		}
+		for _, line := range tr.Mail.Content {
+			if fromLine.MatchString(line) {
+				line = ">" + line
+			}
+			if _, err := bw.WriteString(line + "\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := bw.WriteString("\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
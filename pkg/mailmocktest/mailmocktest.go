@@ -0,0 +1,214 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Linking this library statically or dynamically with other modules is
+// making a combined work based on this library.  Thus, the terms and
+// conditions of the GNU General Public License cover the whole
+// combination.
+//
+// As a special exception, the copyright holders of this library give you
+// permission to link this library with independent modules to produce an
+// executable, regardless of the license terms of these independent
+// modules, and to copy and distribute the resulting executable under
+// terms of your choice, provided that you also meet, for each linked
+// independent module, the terms and conditions of the license of that
+// module.  An independent module is a module which is not derived from
+// or based on this library.  If you modify this library, you may extend
+// this exception to your version of the library, but you are not
+// obligated to do so.  If you do not wish to do so, delete this
+// exception statement from your version.
+
+// Package mailmocktest starts Mailmock inside Go tests, without Docker.
+//
+// Each Server listens on random free ports and stores its transactions in its own namespace,
+// so tests using separate servers can run in parallel.
+package mailmocktest
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/broker"
+	"github.com/adrienaury/mailmock/internal/httpd"
+	"github.com/adrienaury/mailmock/internal/log"
+	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/pkg/client"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+)
+
+// counter is used to give each server a unique namespace.
+var counter int32
+
+// Server is a running instance of Mailmock.
+type Server struct {
+	SMTPAddr  string // address and port of the SMTP server, e.g. 127.0.0.1:41025
+	URL       string // base URL of the REST API, e.g. http://127.0.0.1:41080
+	Namespace string // namespace of the transactions received by this server
+	Store     *Store // transactions received by this server
+}
+
+// Start starts a SMTP server and the REST API on random free ports, they are stopped when the test ends.
+func Start(t testing.TB) *Server {
+	return StartWithOptions(t, smtpd.Options{})
+}
+
+// StartWithOptions is like Start, with optional behaviours of the SMTP server.
+func StartWithOptions(t testing.TB, options smtpd.Options) *Server {
+	t.Helper()
+
+	name := fmt.Sprintf("mailmocktest-%d", atomic.AddInt32(&counter, 1))
+	repo := repository.Namespace(name)
+	repo.Reset()
+
+	smtpLn, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("mailmocktest: failed to listen: %v", err)
+	}
+	httpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		smtpLn.Close()
+		t.Fatalf("mailmocktest: failed to listen: %v", err)
+	}
+
+	var th smtpd.TransactionHandler = func(tr *smtpd.Transaction) {
+		id := repo.Store(tr)
+		broker.Publish(broker.Event{Type: broker.TypeTransaction, Namespace: name, ID: id, Data: tr})
+	}
+	smtpsrv := smtpd.NewServer(name, "127.0.0.1", "0", &th, log.LoggerNoop{})
+	smtpsrv.SetOptions(options)
+	httpsrv := httpd.NewServer(name, "127.0.0.1", "0", log.LoggerNoop{})
+	httpsrv.AddListener(smtpsrv)
+
+	stop := make(chan struct{})
+	done := make(chan struct{}, 2)
+	go func() {
+		_ = smtpsrv.Serve(smtpLn, stop)
+		done <- struct{}{}
+	}()
+	go func() {
+		_ = httpsrv.Serve(httpLn, stop)
+		done <- struct{}{}
+	}()
+
+	t.Cleanup(func() {
+		close(stop)
+		smtpLn.Close() // interrupts the pending accept
+		<-done
+		<-done
+		repo.Reset()
+		repository.Remove(name)
+	})
+
+	return &Server{
+		SMTPAddr:  smtpLn.Addr().String(),
+		URL:       "http://" + httpLn.Addr().String(),
+		Namespace: name,
+		Store:     &Store{repo},
+	}
+}
+
+// Client returns a client of the REST API, scoped to the namespace of the server.
+func (srv *Server) Client() *client.Client {
+	return client.New(srv.URL).Namespace(srv.Namespace)
+}
+
+// RequireMailTo checks that a completed transaction has the given recipient (case insensitive, with or without
+// angle brackets) and returns it.
+// The test fails immediately otherwise.
+func (srv *Server) RequireMailTo(t testing.TB, addr string) *smtpd.Transaction {
+	t.Helper()
+	recipients := []string{}
+	for _, tr := range srv.Store.Transactions() {
+		if tr.State != smtpd.TSCompleted {
+			continue
+		}
+		for _, rcpt := range tr.Mail.Envelope.Recipients {
+			if strings.EqualFold(strings.Trim(rcpt, "<>"), strings.Trim(addr, "<>")) {
+				return tr
+			}
+			recipients = append(recipients, rcpt)
+		}
+	}
+	t.Fatalf("mailmocktest: no mail to %v, received mails to %v", addr, recipients)
+	return nil
+}
+
+// WaitForMessages waits until at least n completed transactions are received and returns them in order of reception.
+// The test fails immediately if the timeout expires first.
+func (srv *Server) WaitForMessages(t testing.TB, n int, timeout time.Duration) []*smtpd.Transaction {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		completed := []*smtpd.Transaction{}
+		for _, tr := range srv.Store.Transactions() {
+			if tr.State == smtpd.TSCompleted {
+				completed = append(completed, tr)
+			}
+		}
+		if len(completed) >= n {
+			return completed
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("mailmocktest: received %v mails after %v, expected %v", len(completed), timeout, n)
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Store gives access to the transactions received by a server.
+type Store struct {
+	repo *repository.Repository
+}
+
+// Transactions returns the stored transactions, completed or aborted, in order of reception.
+func (s *Store) Transactions() []*smtpd.Transaction {
+	objs, _ := s.repo.All(0, s.repo.Len())
+	ids := make([]int, 0, len(objs))
+	for id := range objs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	trs := make([]*smtpd.Transaction, 0, len(ids))
+	for _, id := range ids {
+		trs = append(trs, objs[id].(*smtpd.Transaction))
+	}
+	return trs
+}
+
+// Get returns the transaction with the given ID, or nil.
+func (s *Store) Get(id int) *smtpd.Transaction {
+	if tr, ok := s.repo.Use(id).(*smtpd.Transaction); ok {
+		return tr
+	}
+	return nil
+}
+
+// Len returns the number of stored transactions.
+func (s *Store) Len() int {
+	return s.repo.Stats().Count
+}
+
+// Reset removes all stored transactions.
+func (s *Store) Reset() {
+	s.repo.Reset()
+}
@@ -0,0 +1,67 @@
+package mailmocktest_test
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/internal/repository"
+	"github.com/adrienaury/mailmock/pkg/mailmocktest"
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func send(t *testing.T, addr string, to string) {
+	msg := fmt.Sprintf("Subject: test\r\nTo: %v\r\n\r\nThis is the email body\r\n", to)
+	assert.NoError(t, smtp.SendMail(addr, nil, "sender@example.org", []string{to}, []byte(msg)), "")
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	srv := mailmocktest.Start(t)
+
+	send(t, srv.SMTPAddr, "alice@example.net")
+	send(t, srv.SMTPAddr, "bob@example.net")
+
+	trs := srv.WaitForMessages(t, 2, 5*time.Second)
+	assert.Len(t, trs, 2, "")
+	assert.Equal(t, 2, srv.Store.Len(), "")
+
+	tr := srv.RequireMailTo(t, "Bob@example.net")
+	assert.Equal(t, "test", tr.Mail.Header("Subject"), "")
+	assert.Equal(t, tr, srv.Store.Get(1), "")
+
+	rest, err := srv.Client().All(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, rest, 2, "The REST API MUST serve the transactions of the server")
+}
+
+func TestServerIsolation(t *testing.T) {
+	t.Parallel()
+	srv := mailmocktest.StartWithOptions(t, smtpd.Options{TraceHeaders: true})
+
+	send(t, srv.SMTPAddr, "carol@example.net")
+
+	trs := srv.WaitForMessages(t, 1, 5*time.Second)
+	assert.Len(t, trs, 1, "Servers MUST NOT share their transactions")
+	assert.NotEmpty(t, trs[0].Mail.Header("Received"), "")
+
+	srv.Store.Reset()
+	assert.Equal(t, 0, srv.Store.Len(), "")
+}
+
+func TestServerCleanup(t *testing.T) {
+	t.Parallel()
+	var namespace string
+	t.Run("test", func(t *testing.T) {
+		srv := mailmocktest.Start(t)
+		send(t, srv.SMTPAddr, "alice@example.net")
+		srv.WaitForMessages(t, 1, 5*time.Second)
+		namespace = srv.Namespace
+		assert.NotNil(t, repository.Find(namespace), "")
+	})
+
+	assert.Nil(t, repository.Find(namespace), "The namespace of the server MUST be removed at cleanup")
+}
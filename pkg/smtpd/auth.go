@@ -0,0 +1,161 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Linking this library statically or dynamically with other modules is
+// making a combined work based on this library.  Thus, the terms and
+// conditions of the GNU General Public License cover the whole
+// combination.
+//
+// As a special exception, the copyright holders of this library give you
+// permission to link this library with independent modules to produce an
+// executable, regardless of the license terms of these independent
+// modules, and to copy and distribute the resulting executable under
+// terms of your choice, provided that you also meet, for each linked
+// independent module, the terms and conditions of the license of that
+// module.  An independent module is a module which is not derived from
+// or based on this library.  If you modify this library, you may extend
+// this exception to your version of the library, but you are not
+// obligated to do so.  If you do not wish to do so, delete this
+// exception statement from your version.
+
+package smtpd
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net/textproto"
+	"strings"
+)
+
+// Authenticator checks the credentials given by a client with AUTH.
+type Authenticator func(username, password string) bool
+
+// errCancelled is returned when the client cancels the authentication exchange with "*".
+var errCancelled = errors.New("authentication cancelled")
+
+// extensions returns the reply to EHLO, with the extensions available in the current state of the session.
+func (s *Session) extensions() *Response {
+	res := s.r(Extensions)
+	available := []string{}
+	if s.Options.TLS != nil && !s.TLS {
+		available = append(available, "STARTTLS")
+	}
+	if s.Options.AuthRequired || s.Options.Authenticator != nil {
+		available = append(available, "AUTH PLAIN LOGIN")
+	}
+	if s.xclientTrusted {
+		available = append(available, "XCLIENT "+strings.Join(xclientAttributes, " "), "XFORWARD "+strings.Join(xforwardAttributes, " "))
+	}
+	res.Msg = append(append(res.Msg[:1:1], available...), s.replies.extensions(res)...)
+	return res
+}
+
+// authenticate processes the AUTH command with the PLAIN or LOGIN mechanism (RFC 4954).
+func (s *Session) authenticate(args []string) *Response {
+	if !s.Extended || s.State != SSReady || s.User != "" {
+		return s.r(BadSequence)
+	}
+	initial := ""
+	if len(args) > 1 {
+		initial = args[1]
+	}
+
+	var username, password string
+	var err error
+	switch strings.ToUpper(args[0]) {
+	case "PLAIN":
+		var credentials string
+		if credentials, err = s.challenge(initial, ""); err == nil {
+			parts := strings.Split(credentials, "\x00")
+			if len(parts) != 3 {
+				return s.r(ParameterSyntax)
+			}
+			username, password = parts[1], parts[2]
+		}
+	case "LOGIN":
+		if username, err = s.challenge(initial, "Username:"); err == nil {
+			password, err = s.challenge("", "Password:")
+		}
+	default:
+		return s.r(AuthMechanism)
+	}
+	switch {
+	case errors.Is(err, errCancelled):
+		return s.r(AuthCancelled)
+	case err != nil:
+		return s.r(ParameterSyntax)
+	case s.Options.Authenticator != nil && !s.Options.Authenticator(username, password):
+		return s.r(AuthInvalid)
+	}
+	s.User = username
+	return s.r(AuthSucceeded)
+}
+
+// challenge returns the decoded initial response if given, or sends the prompt and returns the decoded response.
+func (s *Session) challenge(initial string, prompt string) (string, error) {
+	response := initial
+	if response == "" {
+		if err := s.reply(&Response{CodeChallenge, []string{base64.StdEncoding.EncodeToString([]byte(prompt))}}); err != nil {
+			return "", err
+		}
+		line, err := s.conn.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		s.record("C: ", "***") // responses are credentials
+		response = line
+	}
+	switch response {
+	case "*":
+		return "", errCancelled
+	case "=":
+		return "", nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(response)
+	return string(decoded), err
+}
+
+// startTLS accepts to start the TLS negotiation, it starts after the reply (RFC 3207).
+func (s *Session) startTLS() *Response {
+	if s.Options.TLS == nil {
+		return s.r(CommandNotImplemented)
+	}
+	if s.TLS || s.State == SSBusy {
+		return s.r(BadSequence)
+	}
+	s.upgrade = true
+	return s.r(TLSReady)
+}
+
+// upgradeTLS negotiates TLS on the connection, the session restarts from the beginning.
+func (s *Session) upgradeTLS() error {
+	s.upgrade = false
+	if s.netConn == nil {
+		return errors.New("no network connection")
+	}
+	conn := tls.Server(s.netConn, s.Options.TLS)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	s.netConn = conn
+	s.conn = textproto.NewConn(conn)
+	s.TLS = true
+	s.Client, s.Extended, s.User = "", false, ""
+	s.State = SSInitiated
+	return nil
+}
@@ -0,0 +1,130 @@
+package smtpd_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestAuthPlain(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{AuthRequired: true},
+		"EHLO localhost", "MAIL FROM:<sender@example.com>", "AUTH PLAIN "+b64("\x00bob\x00secret"),
+		"MAIL FROM:<sender@example.com>", "RCPT TO:<recipient@example.com>", "DATA", "Subject: Test", "", "body", ".", "QUIT")
+
+	assert.Contains(t, strings.Join(s.Transcript, "\n"), "AUTH PLAIN LOGIN", "AUTH MUST be advertised")
+	assert.Contains(t, s.Transcript, "S: 530 5.7.0 Authentication required", "")
+	assert.Contains(t, s.Transcript, "S: 235 2.7.0 Authentication successful", "")
+	assert.Contains(t, s.Transcript, "C: AUTH PLAIN ***", "Credentials MUST NOT be recorded")
+	assert.Equal(t, "bob", s.User, "")
+	assert.Len(t, s.Transactions, 1, "")
+	assert.Equal(t, "bob", s.Transactions[0].User, "")
+}
+
+func TestAuthLogin(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{Authenticator: func(username, password string) bool { return password == "secret" }},
+		"EHLO localhost", "AUTH LOGIN", b64("bob"), b64("wrong"), "AUTH LOGIN "+b64("bob"), "*", "AUTH LOGIN", b64("bob"), b64("secret"), "QUIT")
+
+	assert.Contains(t, s.Transcript, "S: 334 "+b64("Username:"), "")
+	assert.Contains(t, s.Transcript, "S: 334 "+b64("Password:"), "")
+	assert.Contains(t, s.Transcript, "S: 535 5.7.8 Authentication credentials invalid", "")
+	assert.Contains(t, s.Transcript, "S: 501 5.7.0 Authentication cancelled", "")
+	assert.Contains(t, s.Transcript, "S: 235 2.7.0 Authentication successful", "")
+	assert.NotContains(t, strings.Join(s.Transcript, "\n"), b64("secret"), "Credentials MUST NOT be recorded")
+	assert.Contains(t, s.Transcript, "C: ***", "")
+	assert.Equal(t, "bob", s.User, "")
+}
+
+func TestAuthErrors(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{},
+		"HELO localhost", "AUTH PLAIN", "EHLO localhost", "AUTH CRAM-MD5", "AUTH PLAIN !!!", "STARTTLS", "QUIT")
+
+	assert.NotContains(t, strings.Join(s.Transcript, "\n"), "AUTH PLAIN LOGIN", "AUTH MUST NOT be advertised if not configured")
+	assert.Contains(t, s.Transcript, "S: 503 Bad sequence of commands", "AUTH MUST follow EHLO")
+	assert.Contains(t, s.Transcript, "S: 504 5.5.4 Unrecognized authentication type", "")
+	assert.Contains(t, s.Transcript, "S: 501 Syntax error in parameters or arguments", "")
+	assert.Contains(t, s.Transcript, "S: 502 Command not implemented", "STARTTLS MUST NOT be available without TLS configuration")
+}
+
+func TestBanner(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{Banner: []string{"submission.example.com ESMTP", "Authentication required"}}, "QUIT")
+
+	assert.Equal(t, []string{"S: 220-submission.example.com ESMTP", "S: 220 Authentication required"}, s.Transcript[:2], "")
+}
+
+// selfSigned returns a TLS configuration with a self-signed certificate for localhost.
+func selfSigned(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err, "")
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestStartTLS(t *testing.T) {
+	addr, sessions := serveOptions(t, smtpd.Options{TLS: selfSigned(t), AuthRequired: true})
+
+	c, err := smtp.Dial(addr)
+	assert.NoError(t, err, "")
+	assert.NoError(t, c.Hello("localhost"), "")
+	ok, _ := c.Extension("STARTTLS")
+	assert.True(t, ok, "STARTTLS MUST be advertised")
+	assert.NoError(t, c.StartTLS(&tls.Config{InsecureSkipVerify: true}), "") // #nosec G402
+	ok, _ = c.Extension("STARTTLS")
+	assert.False(t, ok, "STARTTLS MUST NOT be advertised after the negotiation")
+	assert.NoError(t, c.Auth(smtp.PlainAuth("", "bob", "secret", "127.0.0.1")), "")
+	assert.NoError(t, c.Mail("sender@example.com"), "")
+	assert.NoError(t, c.Quit(), "")
+
+	select {
+	case s := <-sessions:
+		assert.True(t, s.TLS, "")
+		assert.Equal(t, "bob", s.User, "")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Session not closed")
+	}
+}
+
+func TestImplicitTLS(t *testing.T) {
+	addr, sessions := serveOptions(t, smtpd.Options{TLS: selfSigned(t), ImplicitTLS: true})
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true}) // #nosec G402
+	assert.NoError(t, err, "")
+	c, err := smtp.NewClient(conn, "localhost")
+	assert.NoError(t, err, "")
+	assert.NoError(t, c.Hello("localhost"), "")
+	ok, _ := c.Extension("STARTTLS")
+	assert.False(t, ok, "STARTTLS MUST NOT be advertised on implicit TLS connections")
+	assert.NoError(t, c.Quit(), "")
+
+	select {
+	case s := <-sessions:
+		assert.True(t, s.TLS, "")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Session not closed")
+	}
+}
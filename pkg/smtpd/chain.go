@@ -0,0 +1,159 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Linking this library statically or dynamically with other modules is
+// making a combined work based on this library.  Thus, the terms and
+// conditions of the GNU General Public License cover the whole
+// combination.
+//
+// As a special exception, the copyright holders of this library give you
+// permission to link this library with independent modules to produce an
+// executable, regardless of the license terms of these independent
+// modules, and to copy and distribute the resulting executable under
+// terms of your choice, provided that you also meet, for each linked
+// independent module, the terms and conditions of the license of that
+// module.  An independent module is a module which is not derived from
+// or based on this library.  If you modify this library, you may extend
+// this exception to your version of the library, but you are not
+// obligated to do so.  If you do not wish to do so, delete this
+// exception statement from your version.
+
+package smtpd
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Handler processes a completed or aborted transaction, unlike a TransactionHandler it can fail.
+type Handler func(tr *Transaction) error
+
+// HandlerError is returned by a Chain when one of its handlers fails.
+type HandlerError struct {
+	Handler string // name of the handler
+	Err     error
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("handler %v failed: %v", e.Handler, e.Err)
+}
+
+// Unwrap returns the error of the handler.
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+type namedHandler struct {
+	name    string
+	handler Handler
+	always  bool // called even if a previous handler failed
+}
+
+// Chain is an ordered list of handlers called for each completed or aborted transaction, an error stops the chain.
+//
+// For a completed transaction, the chain is called before the reply to DATA is sent. If a handler returns a *Response
+// as error, for example after inspection of the content, it replaces the reply. Other errors are replied with a 451
+// reply. In both cases the transaction is aborted, following handlers are not called unless they were added with
+// UseAlways, they are then called with the refused transaction.
+type Chain struct {
+	mutex    sync.RWMutex
+	handlers []namedHandler // never modified once published, changes build a new slice
+}
+
+// NewChain creates a chain of handlers, called in the given order.
+func NewChain() *Chain {
+	return &Chain{}
+}
+
+// Use appends a handler to the chain.
+func (c *Chain) Use(name string, h Handler) *Chain {
+	return c.insert("", namedHandler{name, h, false})
+}
+
+// UseAlways appends a handler to the chain, called for every transaction even if a previous handler failed. It is
+// meant for handlers recording transactions, such as storage, notifications or metrics.
+func (c *Chain) UseAlways(name string, h Handler) *Chain {
+	return c.insert("", namedHandler{name, h, true})
+}
+
+// UseBefore inserts a handler before the handler named before, or appends it if there is no such handler.
+func (c *Chain) UseBefore(before string, name string, h Handler) *Chain {
+	return c.insert(before, namedHandler{name, h, false})
+}
+
+// insert inserts a handler before the handler named before, or appends it if there is no such handler. A new slice
+// is built so that the handlers being called by Handle are left unchanged.
+func (c *Chain) insert(before string, nh namedHandler) *Chain {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	i := len(c.handlers)
+	for j, h := range c.handlers {
+		if before != "" && h.name == before {
+			i = j
+			break
+		}
+	}
+	handlers := make([]namedHandler, len(c.handlers)+1)
+	copy(handlers, c.handlers[:i])
+	handlers[i] = nh
+	copy(handlers[i+1:], c.handlers[i:])
+	c.handlers = handlers
+	return c
+}
+
+// Names returns the names of the handlers, in order.
+func (c *Chain) Names() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	names := make([]string, 0, len(c.handlers))
+	for _, nh := range c.handlers {
+		names = append(names, nh.name)
+	}
+	return names
+}
+
+// Handle calls the handlers in order until one of them fails, its error is returned as a *HandlerError. Handlers
+// added with UseAlways are called anyway.
+func (c *Chain) Handle(tr *Transaction) error {
+	return c.handle(tr, nil)
+}
+
+// handle calls the handlers in order, failed is called with the first error before the remaining handlers added
+// with UseAlways, so that they see the refused transaction. Errors of these remaining handlers are ignored.
+func (c *Chain) handle(tr *Transaction, failed func(err *HandlerError)) error {
+	c.mutex.RLock()
+	handlers := c.handlers
+	c.mutex.RUnlock()
+	var first *HandlerError
+	for _, nh := range handlers {
+		if first != nil && !nh.always {
+			continue
+		}
+		err := nh.handler(tr)
+		if err == nil || first != nil {
+			continue
+		}
+		first = &HandlerError{nh.name, err}
+		if failed != nil {
+			failed(first)
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	return first
+}
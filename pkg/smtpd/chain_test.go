@@ -0,0 +1,125 @@
+package smtpd_test
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"testing"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainOrder(t *testing.T) {
+	called := []string{}
+	handler := func(name string, err error) smtpd.Handler {
+		return func(tr *smtpd.Transaction) error {
+			called = append(called, name)
+			return err
+		}
+	}
+	chain := smtpd.NewChain().
+		Use("first", handler("first", nil)).
+		Use("third", handler("third", nil)).
+		UseBefore("third", "second", handler("second", nil)).
+		UseBefore("missing", "fourth", handler("fourth", nil))
+	assert.Equal(t, []string{"first", "second", "third", "fourth"}, chain.Names(), "")
+
+	assert.NoError(t, chain.Handle(&smtpd.Transaction{}), "")
+	assert.Equal(t, []string{"first", "second", "third", "fourth"}, called, "Handlers MUST be called in order")
+}
+
+func TestChainError(t *testing.T) {
+	errFailed := errors.New("failed")
+	called := 0
+	chain := smtpd.NewChain().
+		Use("fail", func(tr *smtpd.Transaction) error { return errFailed }).
+		Use("next", func(tr *smtpd.Transaction) error { called++; return nil })
+
+	err := chain.Handle(&smtpd.Transaction{})
+	assert.ErrorIs(t, err, errFailed, "")
+	assert.EqualError(t, err, "handler fail failed: failed", "")
+	assert.Equal(t, 0, called, "Handlers following an error MUST NOT be called")
+}
+
+func TestChainReject(t *testing.T) {
+	ended := make(chan *smtpd.Transaction, 2)
+	var th smtpd.TransactionHandler = func(tr *smtpd.Transaction) {
+		ended <- tr
+	}
+	chain := smtpd.NewChain().Use("inspect", func(tr *smtpd.Transaction) error {
+		if tr.Mail.Header("Subject") == "spam" {
+			return &smtpd.Response{Code: smtpd.CodeTransactionFailed, Msg: []string{"Content rejected"}}
+		}
+		return nil
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	srv := smtpd.NewServer("mockmail-chain", "localhost", "1028", &th, nil)
+	srv.SetChain(chain)
+	go func() {
+		if err := srv.ListenAndServe(stop); err != nil {
+			panic(err)
+		}
+	}()
+
+	c, err := dial("127.0.0.1:1028")
+	assert.NoError(t, err, "Can't contact SMTP server")
+
+	for _, subject := range []string{"spam", "ham"} {
+		assert.NoError(t, c.Mail("sender@example.org"), "")
+		assert.NoError(t, c.Rcpt("recipient@example.net"), "")
+		wc, err := c.Data()
+		assert.NoError(t, err, "")
+		_, err = fmt.Fprintf(wc, "Subject: %v\n\nThis is the email body", subject)
+		assert.NoError(t, err, "")
+		err = wc.Close()
+		if subject == "spam" {
+			var perr *textproto.Error
+			assert.ErrorAs(t, err, &perr, "A response returned by a handler MUST replace the reply to DATA")
+			assert.Equal(t, 554, perr.Code, "")
+		} else {
+			assert.NoError(t, err, "")
+		}
+	}
+	assert.NoError(t, c.Quit(), "")
+
+	tr := <-ended
+	assert.Equal(t, smtpd.TSAborted, tr.State, "A rejected transaction MUST be aborted")
+	assert.Equal(t, "554 Content rejected", tr.History[len(tr.History)-1].Line, "")
+	tr = <-ended
+	assert.Equal(t, smtpd.TSCompleted, tr.State, "")
+}
+
+func TestChainAlways(t *testing.T) {
+	errFailed := errors.New("failed")
+	called := []string{}
+	chain := smtpd.NewChain().
+		UseAlways("metrics", func(tr *smtpd.Transaction) error { called = append(called, "metrics"); return nil }).
+		Use("fail", func(tr *smtpd.Transaction) error { return errFailed }).
+		Use("next", func(tr *smtpd.Transaction) error { called = append(called, "next"); return nil }).
+		UseAlways("store", func(tr *smtpd.Transaction) error { called = append(called, "store"); return errors.New("ignored") })
+
+	err := chain.Handle(&smtpd.Transaction{})
+	assert.ErrorIs(t, err, errFailed, "The first error MUST be returned")
+	assert.Equal(t, []string{"metrics", "store"}, called, "Handlers added with UseAlways MUST be called after an error")
+}
+
+func TestChainConcurrent(t *testing.T) {
+	chain := smtpd.NewChain()
+	for i := 0; i < 10; i++ {
+		chain.Use(fmt.Sprint(i), func(tr *smtpd.Transaction) error { return nil })
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			chain.UseBefore("5", fmt.Sprint("before", i), func(tr *smtpd.Transaction) error { return nil })
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, chain.Handle(&smtpd.Transaction{}), "")
+	}
+	<-done
+	assert.Len(t, chain.Names(), 110, "")
+}
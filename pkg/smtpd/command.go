@@ -53,26 +53,42 @@ type cmdDescription struct {
 }
 
 var listOfValidCommands = map[string]cmdDescription{
-	"HELO": {1, true, []string{""}},
-	"EHLO": {1, true, []string{""}},
-	"MAIL": {1, true, []string{"FROM"}},
-	"RCPT": {1, true, []string{"TO"}},
-	"DATA": {0, true, []string{}},
-	"NOOP": {0, false, []string{}},
-	"RSET": {0, true, []string{}},
-	"QUIT": {0, true, []string{}},
-	"VRFY": {1, true, []string{""}},
-	"HELP": {0, false, []string{}},
+	"HELO":     {1, true, []string{""}},
+	"EHLO":     {1, true, []string{""}},
+	"LHLO":     {1, true, []string{""}},
+	"MAIL":     {1, true, []string{"FROM"}},
+	"RCPT":     {1, true, []string{"TO"}},
+	"DATA":     {0, true, []string{}},
+	"NOOP":     {0, false, []string{}},
+	"RSET":     {0, true, []string{}},
+	"QUIT":     {0, true, []string{}},
+	"VRFY":     {1, true, []string{""}},
+	"HELP":     {0, false, []string{}},
+	"AUTH":     {1, false, []string{""}},
+	"STARTTLS": {0, true, []string{}},
+	"XCLIENT":  {1, false, []string{""}},
+	"XFORWARD": {1, false, []string{""}},
+}
+
+// IsCommand returns true if name, in upper case, is a command known by the server.
+func IsCommand(name string) bool {
+	_, ok := listOfValidCommands[name]
+	return ok
 }
 
 // ParseCommand parses a SMTP command, returns appropriate response if the command is malformed
 // If the command is well formed, returned response is nil.
 func ParseCommand(input string) (*Command, *Response) {
+	return parseCommand(input, nil)
+}
+
+// parseCommand parses a SMTP command, responses are given by the replies of the server.
+func parseCommand(input string, replies *Replies) (*Command, *Response) {
 	elmts := strings.Split(input, " ")
 	name := strings.ToUpper(strings.TrimSpace(elmts[0]))
 	desc, ok := listOfValidCommands[name]
 	if !ok {
-		return nil, r(CommandUnrecognized)
+		return nil, replies.reply(CommandUnrecognized)
 	}
 
 	if desc.numberOfArgument != len(desc.argumentNames) {
@@ -82,11 +98,11 @@ func ParseCommand(input string) (*Command, *Response) {
 	elmts = elmts[1:]
 
 	if len(elmts) < desc.numberOfArgument {
-		return nil, r(ParameterSyntax)
+		return nil, replies.reply(ParameterSyntax)
 	}
 
 	if len(elmts) > desc.numberOfArgument && desc.isStrict {
-		return nil, r(ParameterSyntax)
+		return nil, replies.reply(ParameterSyntax)
 	}
 
 	command := &Command{FullCmd: input, Name: name, PositionalArgs: []string{}, NamedArgs: map[string]string{}}
@@ -99,19 +115,19 @@ func ParseCommand(input string) (*Command, *Response) {
 		argName := desc.argumentNames[argPos]
 		if argName != "" {
 			if strings.Count(arg, ":") != 1 {
-				return nil, r(ParameterSyntax)
+				return nil, replies.reply(ParameterSyntax)
 			}
 			argSplit := strings.Split(arg, ":")
 			if strings.ToUpper(argSplit[0]) != argName {
-				return nil, r(ParameterSyntax)
+				return nil, replies.reply(ParameterSyntax)
 			}
 			command.NamedArgs[argName] = strings.TrimSpace(argSplit[1])
 			if command.NamedArgs[argName] == "" {
-				return nil, r(ParameterSyntax)
+				return nil, replies.reply(ParameterSyntax)
 			}
 		} else {
 			if arg == "" {
-				return nil, r(ParameterSyntax)
+				return nil, replies.reply(ParameterSyntax)
 			}
 			command.PositionalArgs = append(command.PositionalArgs, strings.TrimSpace(arg))
 		}
@@ -0,0 +1,109 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Linking this library statically or dynamically with other modules is
+// making a combined work based on this library.  Thus, the terms and
+// conditions of the GNU General Public License cover the whole
+// combination.
+//
+// As a special exception, the copyright holders of this library give you
+// permission to link this library with independent modules to produce an
+// executable, regardless of the license terms of these independent
+// modules, and to copy and distribute the resulting executable under
+// terms of your choice, provided that you also meet, for each linked
+// independent module, the terms and conditions of the license of that
+// module.  An independent module is a module which is not derived from
+// or based on this library.  If you modify this library, you may extend
+// this exception to your version of the library, but you are not
+// obligated to do so.  If you do not wish to do so, delete this
+// exception statement from your version.
+
+package smtpd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// maxLineLength is the maximum total length of a text line including the <CRLF> (RFC 5321 §4.5.3.1.6).
+const maxLineLength = 1000
+
+// Protocol violations found in data
+const (
+	ViolationBareLF      = "bare LF"
+	ViolationBareCR      = "bare CR"
+	ViolationLineTooLong = "line too long"
+)
+
+// readDataStrict reads data until the end of data indicator <CRLF>.<CRLF>, strictly following RFC 5321.
+// Only <CRLF> terminates lines : a bare <LF> or <CR> is kept in the line and recorded as a violation,
+// so that sequences like <LF>.<LF> cannot end the data. Lines are dot-unstuffed (RFC 5321 §4.5.2).
+// Lines longer than the limit are truncated, the rest of the line is discarded as it is read.
+// It returns the lines and the list of protocol violations found, one per line and kind.
+func readDataStrict(r *bufio.Reader) (lines []string, violations []string, err error) {
+	for n := 1; ; n++ {
+		var line []byte
+		length, last, end := 0, byte(0), false
+		for !end {
+			b, err := r.ReadSlice('\n')
+			if err != nil && err != bufio.ErrBufferFull {
+				return lines, violations, err
+			}
+			if len(b) > 1 {
+				last = b[len(b)-2]
+			}
+			end = err == nil && last == '\r'
+			last = b[len(b)-1]
+			length += len(b)
+			if len(line) <= maxLineLength {
+				line = append(line, b...)
+			}
+		}
+		if length > maxLineLength {
+			violations = append(violations, fmt.Sprintf("line %v: %v", n, ViolationLineTooLong))
+			line = line[:maxLineLength]
+		}
+		line = line[:len(line)-2]
+		if bytes.IndexByte(line, '\n') >= 0 {
+			violations = append(violations, fmt.Sprintf("line %v: %v", n, ViolationBareLF))
+		}
+		if bytes.IndexByte(line, '\r') >= 0 {
+			violations = append(violations, fmt.Sprintf("line %v: %v", n, ViolationBareCR))
+		}
+		if len(line) > 0 && line[0] == '.' {
+			if len(line) == 1 {
+				return lines, violations, nil
+			}
+			line = line[1:]
+		}
+		lines = append(lines, string(line))
+	}
+}
+
+// hasViolation returns true if one of the violations is of one of the given kinds.
+func hasViolation(violations []string, kinds ...string) bool {
+	for _, violation := range violations {
+		for _, kind := range kinds {
+			if strings.HasSuffix(violation, ": "+kind) {
+				return true
+			}
+		}
+	}
+	return false
+}
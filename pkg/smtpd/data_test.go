@@ -0,0 +1,74 @@
+package smtpd_test
+
+import (
+	"bytes"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataStrictNominal(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{StrictData: true},
+		"EHLO localhost", "MAIL FROM:<sender@example.com>", "RCPT TO:<recipient@example.com>",
+		"DATA", "Subject: Test", "", "..This line starts with a dot", ".", "QUIT")
+
+	assert.Len(t, s.Transactions, 1, "")
+	assert.Equal(t, smtpd.TSCompleted, s.Transactions[0].State, "")
+	assert.Equal(t, []string{"Subject: Test", "", ".This line starts with a dot"}, s.Transactions[0].Mail.Content, "Lines MUST be dot-unstuffed")
+	assert.Empty(t, s.Transactions[0].Violations, "")
+}
+
+func TestDataStrictBareLF(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{StrictData: true},
+		"EHLO localhost", "MAIL FROM:<sender@example.com>", "RCPT TO:<recipient@example.com>",
+		"DATA", "Subject: Test", "", "This is a test\n.\nMAIL FROM:<smuggled@example.com>", "Bare\rCR", ".", "QUIT")
+
+	assert.Len(t, s.Transactions, 1, "Bare LF MUST NOT end data")
+	assert.Equal(t, smtpd.TSAborted, s.Transactions[0].State, "Data with bare LF MUST be rejected")
+	assert.Equal(t, []string{"line 3: bare LF", "line 4: bare CR"}, s.Transactions[0].Violations, "")
+	assert.Equal(t, []string{"Subject: Test", "", "This is a test\n.\nMAIL FROM:<smuggled@example.com>", "Bare\rCR"}, s.Transactions[0].Mail.Content, "Rejected data MUST be kept")
+	assert.Contains(t, s.Transcript, "S: 554 Bare <CR> or <LF> received, lines must end with <CRLF>", "")
+}
+
+func TestDataStrictBareLFReply(t *testing.T) {
+	custom := smtpd.Replies{Responses: map[smtpd.Resp]smtpd.Response{
+		smtpd.BareLineEnding: {Code: smtpd.CodeMailboxUnavailablePerm, Msg: []string{"Bare LF"}},
+	}}
+	rwc := &MockConn{bytes.NewBufferString(strings.Join([]string{"EHLO localhost", "MAIL FROM:<sender@example.com>",
+		"RCPT TO:<recipient@example.com>", "DATA", "Subject: Test", "", "Bare\nLF", ".", "QUIT"}, "\r\n")), bytes.NewBuffer(nil)}
+	s := smtpd.NewSession(textproto.NewConn(rwc), nil, nil)
+	s.Options = smtpd.Options{StrictData: true}
+	s.SetReplies(custom)
+	s.Serve(make(chan struct{}, 1))
+
+	assert.Len(t, s.Transactions, 1, "")
+	assert.Equal(t, smtpd.TSAborted, s.Transactions[0].State, "")
+	assert.Contains(t, s.Transcript, "S: 550 Bare LF", "Configured reply MUST be used")
+	assert.Equal(t, "554 Bare <CR> or <LF> received, lines must end with <CRLF>", smtpd.DefaultReplies().Responses[smtpd.BareLineEnding].String(), "Default reply MUST NOT be modified")
+}
+
+func TestDataStrictLineTooLong(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{StrictData: true},
+		"EHLO localhost", "MAIL FROM:<sender@example.com>", "RCPT TO:<recipient@example.com>",
+		"DATA", strings.Repeat("a", 998), strings.Repeat("b", 999), strings.Repeat("c", 100000)+"\r", ".", "QUIT")
+
+	assert.Len(t, s.Transactions, 1, "")
+	assert.Equal(t, smtpd.TSAborted, s.Transactions[0].State, "Data with line longer than 1000 octets MUST be rejected")
+	assert.Equal(t, []string{"line 2: line too long", "line 3: line too long"}, s.Transactions[0].Violations, "")
+	assert.Equal(t, []string{strings.Repeat("a", 998), strings.Repeat("b", 998), strings.Repeat("c", 998)}, s.Transactions[0].Mail.Content,
+		"Lines too long MUST be truncated")
+	assert.Contains(t, s.Transcript, "S: 500 Line too long", "")
+}
+
+func TestDataLenient(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{},
+		"EHLO localhost", "MAIL FROM:<sender@example.com>", "RCPT TO:<recipient@example.com>",
+		"DATA", strings.Repeat("a", 999), "Bare\rCR", ".", "QUIT")
+
+	assert.Len(t, s.Transactions, 1, "")
+	assert.Equal(t, smtpd.TSCompleted, s.Transactions[0].State, "Data MUST NOT be checked if strict mode is disabled")
+	assert.Empty(t, s.Transactions[0].Violations, "")
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Linking this library statically or dynamically with other modules is
+// making a combined work based on this library.  Thus, the terms and
+// conditions of the GNU General Public License cover the whole
+// combination.
+//
+// As a special exception, the copyright holders of this library give you
+// permission to link this library with independent modules to produce an
+// executable, regardless of the license terms of these independent
+// modules, and to copy and distribute the resulting executable under
+// terms of your choice, provided that you also meet, for each linked
+// independent module, the terms and conditions of the license of that
+// module.  An independent module is a module which is not derived from
+// or based on this library.  If you modify this library, you may extend
+// this exception to your version of the library, but you are not
+// obligated to do so.  If you do not wish to do so, delete this
+// exception statement from your version.
+
+package smtpd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// addHeaders prepends header fields to the mail of the current transaction, as configured by options.
+func (s *Session) addHeaders() {
+	headers := []string{}
+	if s.Options.TraceHeaders {
+		headers = append(headers, s.traceHeaders()...)
+	}
+	if s.Options.MissingHeaders {
+		if s.Tr.Mail.Header("Date") == "" {
+			headers = append(headers, "Date: "+s.Tr.Received.Format(time.RFC1123Z))
+		}
+		if s.Tr.Mail.Header("Message-ID") == "" {
+			headers = append(headers, fmt.Sprintf("Message-ID: <%v@%v>", s.transactionID(), s.replies.hostname()))
+		}
+	}
+	if len(headers) > 0 {
+		s.Tr.Mail.Content = append(headers, s.Tr.Mail.Content...)
+	}
+}
+
+// traceHeaders returns the Return-Path and Received header fields (RFC 5321 §4.4).
+func (s *Session) traceHeaders() []string {
+	sender := s.Tr.Mail.Envelope.Sender
+	if !strings.HasPrefix(sender, "<") {
+		sender = "<" + sender + ">"
+	}
+
+	from := "from " + s.Client
+	if host, _, err := net.SplitHostPort(s.RemoteAddr); err == nil {
+		from += fmt.Sprintf(" ([%v])", host)
+	}
+
+	by := fmt.Sprintf("\tby %v (Mailmock) with %v id %v", s.replies.hostname(), s.protocol(), s.transactionID())
+
+	date := "; " + s.Tr.Received.Format(time.RFC1123Z)
+	if len(s.Tr.Mail.Envelope.Recipients) == 1 {
+		date = "\tfor " + s.Tr.Mail.Envelope.Recipients[0] + date
+	} else {
+		by += date
+		date = ""
+	}
+
+	headers := []string{"Return-Path: " + sender, "Received: " + from, by}
+	if date != "" {
+		headers = append(headers, date)
+	}
+	return headers
+}
+
+// protocol returns the protocol used by the client, as registered for the "with" clause of the Received header field,
+// with the S and A suffixes for TLS and authenticated sessions (RFC 3848).
+func (s *Session) protocol() string {
+	protocol := "SMTP"
+	switch {
+	case s.Options.LMTP:
+		protocol = "LMTP"
+	case s.Extended:
+		protocol = "ESMTP"
+	default:
+		return protocol
+	}
+	if s.TLS {
+		protocol += "S"
+	}
+	if s.User != "" {
+		protocol += "A"
+	}
+	return protocol
+}
+
+// transactionID returns an identifier of the current transaction, unique across sessions.
+func (s *Session) transactionID() string {
+	return fmt.Sprintf("%v.%v", s.ID, len(s.Transactions)+1)
+}
@@ -0,0 +1,114 @@
+package smtpd_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveWithOptions(t *testing.T, options smtpd.Options, snd ...string) *smtpd.Session {
+	rwc := &MockConn{bytes.NewBufferString(strings.Join(snd, "\r\n")), bytes.NewBuffer(nil)}
+	s := smtpd.NewSession(textproto.NewConn(rwc), nil, nil)
+	s.Options = options
+	s.SetReplies(replies)
+	s.Serve(make(chan struct{}, 1))
+	return s
+}
+
+func TestHeadersNone(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{},
+		"EHLO localhost", "MAIL FROM:<sender@example.com>", "RCPT TO:<recipient@example.com>",
+		"DATA", "Subject: Test", "", "This is a test", ".", "QUIT")
+
+	assert.Len(t, s.Transactions, 1, "")
+	assert.Equal(t, []string{"Subject: Test", "", "This is a test"}, s.Transactions[0].Mail.Content, "Mail content MUST NOT be modified by default")
+}
+
+func TestHeadersTrace(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{TraceHeaders: true},
+		"EHLO client.example.com", "MAIL FROM:<sender@example.com>", "RCPT TO:<recipient@example.com>",
+		"DATA", "Subject: Test", "", "This is a test", ".", "QUIT")
+
+	assert.Len(t, s.Transactions, 1, "")
+	content := s.Transactions[0].Mail.Content
+	assert.Len(t, content, 7, "")
+	assert.Equal(t, "Return-Path: <sender@example.com>", content[0], "")
+	assert.Equal(t, "Received: from client.example.com", content[1], "")
+	assert.Regexp(t, `^\tby \S+ \(Mailmock\) with ESMTP id [0-9a-f]+\.1$`, content[2], "")
+	assert.Regexp(t, `^\tfor <recipient@example.com>; \w{3}, \d{2} \w{3} \d{4} \d{2}:\d{2}:\d{2} [+-]\d{4}$`, content[3], "")
+	assert.Equal(t, []string{"Subject: Test", "", "This is a test"}, content[4:], "")
+	assert.Equal(t, "This is a test", s.Transactions[0].History[len(s.Transactions[0].History)-3].Line, "History MUST NOT be modified")
+}
+
+func TestHeadersTraceMultipleRecipients(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{TraceHeaders: true},
+		"HELO client.example.com", "MAIL FROM:sender@example.com", "RCPT TO:<a@example.com>", "RCPT TO:<b@example.com>",
+		"DATA", "Subject: Test", "", "This is a test", ".", "QUIT")
+
+	content := s.Transactions[0].Mail.Content
+	assert.Len(t, content, 6, "")
+	assert.Equal(t, "Return-Path: <sender@example.com>", content[0], "")
+	assert.Regexp(t, `^\tby \S+ \(Mailmock\) with SMTP id [0-9a-f]+\.1; .+$`, content[2], "")
+}
+
+func TestHeadersMissing(t *testing.T) {
+	s := serveWithOptions(t, smtpd.Options{MissingHeaders: true},
+		"EHLO localhost", "MAIL FROM:<sender@example.com>", "RCPT TO:<recipient@example.com>",
+		"DATA", "Subject: Test", "", "This is a test", ".",
+		"MAIL FROM:<sender@example.com>", "RCPT TO:<recipient@example.com>",
+		"DATA", "Date: Mon, 02 Jan 2006 15:04:05 -0700", "Message-ID: <test@example.com>", "", "This is a test", ".", "QUIT")
+
+	assert.Len(t, s.Transactions, 2, "")
+	content := s.Transactions[0].Mail.Content
+	assert.Len(t, content, 5, "")
+	assert.Regexp(t, `^Date: \w{3}, \d{2} \w{3} \d{4} \d{2}:\d{2}:\d{2} [+-]\d{4}$`, content[0], "")
+	assert.Regexp(t, `^Message-ID: <[0-9a-f]+\.1@\S+>$`, content[1], "")
+	assert.Equal(t, []string{"Date: Mon, 02 Jan 2006 15:04:05 -0700", "Message-ID: <test@example.com>", "", "This is a test"}, s.Transactions[1].Mail.Content, "Existing headers MUST NOT be added")
+}
+
+func TestHeadersTraceProtocol(t *testing.T) {
+	tests := []struct {
+		tls, auth bool
+		protocol  string
+	}{
+		{false, false, "ESMTP"},
+		{true, false, "ESMTPS"},
+		{false, true, "ESMTPA"},
+		{true, true, "ESMTPSA"},
+	}
+	for _, test := range tests {
+		addr, sessions := serveOptions(t, smtpd.Options{TLS: selfSigned(t), TraceHeaders: true})
+
+		c, err := smtp.Dial(addr)
+		assert.NoError(t, err, "")
+		if test.tls {
+			assert.NoError(t, c.StartTLS(&tls.Config{InsecureSkipVerify: true}), "") // #nosec G402
+		}
+		if test.auth {
+			assert.NoError(t, c.Auth(smtp.PlainAuth("", "bob", "secret", "127.0.0.1")), "")
+		}
+		assert.NoError(t, c.Mail("sender@example.com"), "")
+		assert.NoError(t, c.Rcpt("recipient@example.com"), "")
+		w, err := c.Data()
+		assert.NoError(t, err, "")
+		_, err = w.Write([]byte("Subject: Test\r\n\r\nThis is a test\r\n"))
+		assert.NoError(t, err, "")
+		assert.NoError(t, w.Close(), "")
+		assert.NoError(t, c.Quit(), "")
+
+		select {
+		case s := <-sessions:
+			assert.Len(t, s.Transactions, 1, "")
+			assert.Regexp(t, `^\tby \S+ \(Mailmock\) with `+test.protocol+` id `, s.Transactions[0].Mail.Content[2], "")
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "Session not closed")
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package smtpd_test
+
+import (
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLMTPUnixSocket(t *testing.T) {
+	ended := make(chan *smtpd.Transaction, 1)
+	var th smtpd.TransactionHandler = func(tr *smtpd.Transaction) {
+		ended <- tr
+	}
+	path := filepath.Join(t.TempDir(), "lmtp.sock")
+	stop := make(chan struct{})
+	defer close(stop)
+	srv := smtpd.NewUnixServer("mockmail-lmtp", path, &th, nil)
+	srv.SetOptions(smtpd.Options{LMTP: true})
+	go func() {
+		if err := srv.ListenAndServe(stop); err != nil {
+			panic(err)
+		}
+	}()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 20; i++ {
+		if conn, err = net.Dial("unix", path); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.NoError(t, err, "Can't contact LMTP server")
+	c := textproto.NewConn(conn)
+	defer c.Close()
+
+	cmd := func(expected int, format string, args ...interface{}) {
+		if format != "" {
+			assert.NoError(t, c.PrintfLine(format, args...), "")
+		}
+		_, _, err := c.ReadResponse(expected)
+		assert.NoError(t, err, format)
+	}
+	cmd(220, "")
+	cmd(500, "EHLO localhost")
+	cmd(250, "LHLO localhost")
+	cmd(250, "MAIL FROM:<sender@example.org>")
+	cmd(250, "RCPT TO:<bob@example.com>")
+	cmd(250, "RCPT TO:<alice@example.com>")
+	cmd(354, "DATA")
+	cmd(250, "Subject: test\r\n\r\nThis is the email body\r\n.")
+	cmd(250, "") // one reply per recipient
+	cmd(221, "QUIT")
+
+	select {
+	case tr := <-ended:
+		assert.Equal(t, smtpd.TSCompleted, tr.State, "")
+		n := len(tr.History)
+		assert.Equal(t, "250 <bob@example.com> OK", tr.History[n-2].Line, "")
+		assert.Equal(t, "250 <alice@example.com> OK", tr.History[n-1].Line, "")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Transaction not handled")
+	}
+}
@@ -51,6 +51,38 @@ type Mail struct {
 	Content  []string `json:"content"`
 }
 
+// Header returns the value of the first header field with the given name (case insensitive),
+// or an empty string if the field is missing. Folded values are unfolded.
+func (m Mail) Header(name string) string {
+	value, found := "", false
+	for _, line := range m.Content {
+		switch {
+		case line == "":
+			return strings.TrimSpace(value)
+		case line[0] == ' ' || line[0] == '\t':
+			if found {
+				value += line // folded header field
+			}
+		case found:
+			return strings.TrimSpace(value)
+		default:
+			if i := strings.Index(line, ":"); i > 0 && strings.EqualFold(line[:i], name) {
+				value, found = line[i+1:], true
+			}
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
+// Size returns the size of the content in bytes, lines are counted with their CRLF terminator.
+func (m Mail) Size() int {
+	size := 0
+	for _, line := range m.Content {
+		size += len(line) + 2
+	}
+	return size
+}
+
 func (m Mail) String() string {
 	return fmt.Sprintf("MAIL FROM:%v\nRCPT TO:%v\n%v", m.Envelope.Sender, strings.Join(m.Envelope.Recipients, ", "), strings.Join(m.Content, "\n"))
 }
@@ -11,3 +11,17 @@ func TestMail(t *testing.T) {
 	mail := smtpd.Mail{}
 	assert.Equal(t, "MAIL FROM:\nRCPT TO:\n", mail.String(), "Invalid mail string representation")
 }
+
+func TestMailSize(t *testing.T) {
+	mail := smtpd.Mail{Content: []string{"Subject: test", "", "body"}}
+	assert.Equal(t, 23, mail.Size(), "Invalid mail size")
+	assert.Equal(t, 23, (&smtpd.Transaction{Mail: mail}).Size(), "Invalid transaction size")
+}
+
+func TestMailHeader(t *testing.T) {
+	mail := smtpd.Mail{Content: []string{"Subject: test", "X-Folded: a", "\tb", "", "X-Body: body"}}
+	assert.Equal(t, "test", mail.Header("subject"), "Header names MUST be case insensitive")
+	assert.Equal(t, "a\tb", mail.Header("X-Folded"), "Folded headers MUST be unfolded")
+	assert.Equal(t, "", mail.Header("X-Body"), "Body MUST NOT be parsed as header")
+	assert.Equal(t, "", mail.Header("X-Missing"), "")
+}
@@ -2,9 +2,11 @@ package smtpd_test
 
 import (
 	"fmt"
+	"net"
 	"net/smtp"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/adrienaury/mailmock/pkg/smtpd"
 	"github.com/stretchr/testify/assert"
@@ -14,12 +16,17 @@ var th smtpd.TransactionHandler = func(tr *smtpd.Transaction) {
 	fmt.Println(tr)
 }
 
+// replies of servers and sessions of tests, without the host name
+var replies = smtpd.Replies{Responses: map[smtpd.Resp]smtpd.Response{
+	smtpd.Ready:        {Code: smtpd.CodeReady, Msg: []string{"Service ready"}},
+	smtpd.Closing:      {Code: smtpd.CodeClosing, Msg: []string{"Service closing transmission channel"}},
+	smtpd.NotAvailable: {Code: smtpd.CodeNotAvailable, Msg: []string{"Service not available, closing transmission channel"}},
+	smtpd.Extensions:   {Code: smtpd.CodeSuccess, Msg: []string{"OK (extended)"}},
+}}
+
 func TestMain(m *testing.M) {
-	smtpd.SetReply(smtpd.Ready, "Service ready")
-	smtpd.SetReply(smtpd.Closing, "Service closing transmission channel")
-	smtpd.SetReply(smtpd.NotAvailable, "Service not available, closing transmission channel")
-	smtpd.SetReply(smtpd.Extensions, "OK (extended)")
 	srv := smtpd.NewServer("mockmail", "localhost", "1024", &th, nil)
+	srv.SetReplies(replies)
 	go func() {
 		if err := srv.ListenAndServe(make(chan struct{})); err != nil {
 			panic(err)
@@ -28,8 +35,45 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
+// dial connects to the SMTP server at addr, waiting for it to be listening.
+func dial(addr string) (c *smtp.Client, err error) {
+	for i := 0; i < 20; i++ {
+		if c, err = smtp.Dial(addr); err == nil {
+			return c, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, err
+}
+
+// serveOptions starts a server with the options, it returns its address and a channel receiving its closed sessions.
+func serveOptions(t *testing.T, options smtpd.Options) (string, chan *smtpd.Session) {
+	sessions := make(chan *smtpd.Session, 1)
+	var sh smtpd.SessionHandler = func(s *smtpd.Session, e smtpd.SessionEvent) {
+		if e == smtpd.SEClosed {
+			sessions <- s
+		}
+	}
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "")
+	stop := make(chan struct{})
+	srv := smtpd.NewServer("mockmail-options", "127.0.0.1", "0", nil, nil)
+	srv.SetOptions(options)
+	srv.SetSessionHandler(&sh)
+	go func() { _ = srv.Serve(ln, stop) }()
+	t.Cleanup(func() { close(stop) })
+	return ln.Addr().String(), sessions
+}
+
+// trusted returns the networks of the CIDR notation.
+func trusted(t *testing.T, cidr string) []*net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	assert.NoError(t, err, "")
+	return []*net.IPNet{network}
+}
+
 func TestNominal(t *testing.T) {
-	c, err := smtp.Dial("127.0.0.1:1024")
+	c, err := dial("127.0.0.1:1024")
 	assert.NoError(t, err, "Can't contact SMTP server")
 	assert.NotNil(t, c, "No connection to SMTP server")
 
@@ -52,3 +96,105 @@ func TestNominal(t *testing.T) {
 	err = c.Quit()
 	assert.NoError(t, err, "SMTP server MUST NOT return an error to a valid transaction")
 }
+
+func TestSessionEvents(t *testing.T) {
+	events := make(chan smtpd.SessionEvent, 10)
+	var sh smtpd.SessionHandler = func(s *smtpd.Session, e smtpd.SessionEvent) {
+		events <- e
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	srv := smtpd.NewServer("mockmail-events", "localhost", "1025", nil, nil)
+	srv.SetSessionHandler(&sh)
+	go func() {
+		if err := srv.ListenAndServe(stop); err != nil {
+			panic(err)
+		}
+	}()
+
+	c, err := dial("127.0.0.1:1025")
+	assert.NoError(t, err, "Can't contact SMTP server")
+
+	err = c.Hello("localhost")
+	assert.NoError(t, err, "SMTP server MUST NOT return an error to a valid greeting")
+
+	err = c.Quit()
+	assert.NoError(t, err, "SMTP server MUST NOT return an error to a valid transaction")
+
+	for _, expected := range []smtpd.SessionEvent{smtpd.SEConnected, smtpd.SEHello, smtpd.SEClosed} {
+		select {
+		case e := <-events:
+			assert.Equal(t, expected, e, "Session events MUST be emitted in order")
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "Session event not received", string(expected))
+		}
+	}
+}
+
+func TestCommandHandler(t *testing.T) {
+	commands := make(chan string, 10)
+	var ch smtpd.CommandHandler = func(s *smtpd.Session, name string, res *smtpd.Response, duration time.Duration) {
+		commands <- fmt.Sprintf("%v %v", name, res.Code)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	srv := smtpd.NewServer("mockmail-commands", "localhost", "1026", nil, nil)
+	srv.SetCommandHandler(&ch)
+	go func() {
+		if err := srv.ListenAndServe(stop); err != nil {
+			panic(err)
+		}
+	}()
+
+	c, err := dial("127.0.0.1:1026")
+	assert.NoError(t, err, "Can't contact SMTP server")
+
+	err = c.Hello("localhost")
+	assert.NoError(t, err, "SMTP server MUST NOT return an error to a valid greeting")
+
+	err = c.Rcpt("recipient@example.net")
+	assert.Error(t, err, "SMTP server MUST return an error to a command out of sequence")
+
+	err = c.Quit()
+	assert.NoError(t, err, "SMTP server MUST NOT return an error to a valid transaction")
+
+	for _, expected := range []string{"EHLO 250", "RCPT 503", "QUIT 221"} {
+		select {
+		case cmd := <-commands:
+			assert.Equal(t, expected, cmd, "Command handler MUST be called after each command")
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "Command not handled", expected)
+		}
+	}
+}
+
+func TestServerStatus(t *testing.T) {
+	stop := make(chan struct{})
+	srv := smtpd.NewServer("mockmail-status", "localhost", "1027", nil, nil)
+	assert.Equal(t, smtpd.SVStarting, srv.Status().State, "")
+
+	done := make(chan struct{})
+	go func() {
+		if err := srv.ListenAndServe(stop); err != nil {
+			panic(err)
+		}
+		close(done)
+	}()
+
+	c, err := dial("127.0.0.1:1027")
+	assert.NoError(t, err, "Can't contact SMTP server")
+	assert.Equal(t, smtpd.SVListening, srv.Status().State, "Server MUST be listening once it accepts connections")
+	assert.Equal(t, "127.0.0.1:1027", srv.Status().Addr, "")
+
+	err = c.Quit()
+	assert.NoError(t, err, "SMTP server MUST NOT return an error to a valid transaction")
+
+	close(stop)
+	select {
+	case <-done:
+		assert.Equal(t, smtpd.SVStopped, srv.Status().State, "")
+		assert.Equal(t, 0, srv.Status().Sessions, "")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Server not stopped")
+	}
+}
@@ -0,0 +1,89 @@
+package smtpd_test
+
+import (
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+// proxySession sends the header and a SMTP session, and returns the session recorded by the server.
+func proxySession(t *testing.T, addr string, sessions chan *smtpd.Session, header []byte) *smtpd.Session {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err, "")
+	_, err = conn.Write(header)
+	assert.NoError(t, err, "")
+	c := textproto.NewConn(conn)
+	defer c.Close()
+	_, _, err = c.ReadResponse(220)
+	assert.NoError(t, err, "")
+	assert.NoError(t, c.PrintfLine("QUIT"), "")
+	_, _, err = c.ReadResponse(221)
+	assert.NoError(t, err, "")
+
+	select {
+	case s := <-sessions:
+		return s
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Session not closed")
+	}
+	return nil
+}
+
+func TestProxyV1(t *testing.T) {
+	addr, sessions := serveOptions(t, smtpd.Options{ProxyTrusted: trusted(t, "127.0.0.0/8")})
+
+	s := proxySession(t, addr, sessions, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 25\r\n"))
+	assert.Equal(t, "192.0.2.1:56324", s.RemoteAddr, "The address of the client MUST be given by the proxy")
+	assert.Regexp(t, `^127\.0\.0\.1:\d+$`, s.ProxyAddr, "")
+
+	s = proxySession(t, addr, sessions, []byte("PROXY UNKNOWN\r\n"))
+	assert.Regexp(t, `^127\.0\.0\.1:\d+$`, s.RemoteAddr, "")
+}
+
+func TestProxyV2(t *testing.T) {
+	addr, sessions := serveOptions(t, smtpd.Options{ProxyTrusted: trusted(t, "127.0.0.0/8")})
+
+	header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	header = append(header, 0x21, 0x21, 0, 36) // PROXY command, TCP over IPv6, 36 bytes of addresses
+	header = append(header, net.ParseIP("2001:db8::1")...)
+	header = append(header, net.ParseIP("2001:db8::2")...)
+	header = append(header, 0xdc, 0x04, 0, 25)
+	s := proxySession(t, addr, sessions, header)
+	assert.Equal(t, "[2001:db8::1]:56324", s.RemoteAddr, "The address of the client MUST be given by the proxy")
+}
+
+func TestProxyUntrusted(t *testing.T) {
+	addr, sessions := serveOptions(t, smtpd.Options{ProxyTrusted: trusted(t, "10.0.0.0/8")})
+
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err, "")
+	c := textproto.NewConn(conn)
+	defer c.Close()
+	_, _, err = c.ReadResponse(220)
+	assert.NoError(t, err, "")
+	assert.NoError(t, c.PrintfLine("PROXY TCP4 192.0.2.1 198.51.100.1 56324 25"), "")
+	_, _, err = c.ReadResponse(500)
+	assert.NoError(t, err, "Headers from untrusted sources MUST be refused")
+	assert.NoError(t, c.PrintfLine("QUIT"), "")
+
+	s := <-sessions
+	assert.Regexp(t, `^127\.0\.0\.1:\d+$`, s.RemoteAddr, "")
+	assert.Empty(t, s.ProxyAddr, "")
+}
+
+func TestProxyMissingHeader(t *testing.T) {
+	addr, _ := serveOptions(t, smtpd.Options{ProxyTrusted: trusted(t, "127.0.0.0/8")})
+
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err, "")
+	defer conn.Close()
+	_, err = conn.Write([]byte("EHLO localhost\r\n"))
+	assert.NoError(t, err, "")
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)), "")
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err, "Connections of trusted sources without header MUST be closed")
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Linking this library statically or dynamically with other modules is
+// making a combined work based on this library.  Thus, the terms and
+// conditions of the GNU General Public License cover the whole
+// combination.
+//
+// As a special exception, the copyright holders of this library give you
+// permission to link this library with independent modules to produce an
+// executable, regardless of the license terms of these independent
+// modules, and to copy and distribute the resulting executable under
+// terms of your choice, provided that you also meet, for each linked
+// independent module, the terms and conditions of the license of that
+// module.  An independent module is a module which is not derived from
+// or based on this library.  If you modify this library, you may extend
+// this exception to your version of the library, but you are not
+// obligated to do so.  If you do not wish to do so, delete this
+// exception statement from your version.
+
+package smtpd
+
+import (
+	"strings"
+)
+
+// Replies configures what a server replies to clients, default values are used for missing parts.
+type Replies struct {
+	Hostname   string            `json:"hostname"`   // replaces "<domain>" in replies, the name of the machine if empty
+	Responses  map[Resp]Response `json:"replies"`    // replaces the default responses
+	Extensions []string          `json:"extensions"` // keywords advertised in reply to EHLO, with those of the enabled features
+}
+
+// DefaultReplies returns a copy of the replies used for missing parts of the Replies of a server.
+func DefaultReplies() Replies {
+	return Replies{Hostname: hostname, Responses: responses}.copy()
+}
+
+// copy returns a deep copy, so that the replies of sessions in progress are never changed.
+func (rs Replies) copy() Replies {
+	responses := make(map[Resp]Response, len(rs.Responses))
+	for resp, response := range rs.Responses {
+		responses[resp] = Response{response.Code, append([]string{}, response.Msg...)}
+	}
+	rs.Responses = responses
+	if rs.Extensions != nil {
+		rs.Extensions = append([]string{}, rs.Extensions...)
+	}
+	return rs
+}
+
+// hostname returns the name of the server.
+func (rs *Replies) hostname() string {
+	if rs == nil || rs.Hostname == "" {
+		return hostname
+	}
+	return rs.Hostname
+}
+
+// reply returns the response, "<domain>" is replaced by the name of the server.
+func (rs *Replies) reply(resp Resp) *Response {
+	response, ok := Response{}, false
+	if rs != nil {
+		response, ok = rs.Responses[resp]
+	}
+	if !ok {
+		response, ok = responses[resp]
+	}
+	if !ok {
+		response = responses[Misconfiguration]
+	}
+	msg := make([]string, len(response.Msg))
+	for i, line := range response.Msg {
+		msg[i] = strings.ReplaceAll(line, "<domain>", rs.hostname())
+	}
+	return &Response{response.Code, msg}
+}
+
+// extensions returns the keywords advertised in reply to EHLO, after the first line of the Extensions reply.
+func (rs *Replies) extensions(res *Response) []string {
+	if rs == nil || rs.Extensions == nil {
+		return res.Msg[1:]
+	}
+	return rs.Extensions
+}
@@ -0,0 +1,117 @@
+package smtpd_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveReplies starts a server with the given replies and returns its address.
+func serveReplies(t *testing.T, name string, replies smtpd.Replies) (*smtpd.Server, string) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "")
+	stop := make(chan struct{})
+	srv := smtpd.NewServer(name, "127.0.0.1", "0", nil, nil)
+	srv.SetReplies(replies)
+	go func() { _ = srv.Serve(ln, stop) }()
+	t.Cleanup(func() { close(stop) })
+	return srv, ln.Addr().String()
+}
+
+// greet returns the greeting and the reply to EHLO of the server.
+func greet(t *testing.T, addr string) (string, string) {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err, "")
+	c := textproto.NewConn(conn)
+	defer c.Close()
+	_, greeting, err := c.ReadResponse(220)
+	assert.NoError(t, err, "")
+	assert.NoError(t, c.PrintfLine("EHLO localhost"), "")
+	_, extensions, err := c.ReadResponse(250)
+	assert.NoError(t, err, "")
+	assert.NoError(t, c.PrintfLine("QUIT"), "")
+	_, _, err = c.ReadResponse(221)
+	assert.NoError(t, err, "")
+	return greeting, extensions
+}
+
+func TestRepliesPerServer(t *testing.T) {
+	_, addr1 := serveReplies(t, "mockmail-replies-1", smtpd.Replies{
+		Hostname:   "mx1.example.com",
+		Extensions: []string{"8BITMIME", "HELP"},
+	})
+	srv2, addr2 := serveReplies(t, "mockmail-replies-2", smtpd.Replies{
+		Hostname: "mx2.example.com",
+		Responses: map[smtpd.Resp]smtpd.Response{
+			smtpd.Ready: {Code: smtpd.CodeReady, Msg: []string{"<domain> ESMTP", "Welcome"}},
+		},
+	})
+
+	greeting, extensions := greet(t, addr1)
+	assert.Equal(t, "mx1.example.com Service ready", greeting, "")
+	assert.Equal(t, "mx1.example.com\n8BITMIME\nHELP", extensions, "")
+
+	greeting, extensions = greet(t, addr2)
+	assert.Equal(t, "mx2.example.com ESMTP\nWelcome", greeting, "Replies of a server MUST NOT change the replies of other servers")
+	assert.Equal(t, "mx2.example.com\nHELP", extensions, "Default extensions MUST be advertised if not set")
+
+	replies := srv2.Replies()
+	replies.Responses[smtpd.Ready] = smtpd.Response{Code: smtpd.CodeReady, Msg: []string{"Changed"}}
+	greeting, _ = greet(t, addr2)
+	assert.Equal(t, "mx2.example.com ESMTP\nWelcome", greeting, "Replies MUST only be changed with SetReplies")
+
+	srv2.SetReplies(replies)
+	greeting, _ = greet(t, addr2)
+	assert.Equal(t, "Changed", greeting, "New sessions MUST use the new replies")
+}
+
+func TestRepliesJSON(t *testing.T) {
+	replies := smtpd.Replies{
+		Hostname:  "mx.example.com",
+		Responses: map[smtpd.Resp]smtpd.Response{smtpd.BareLineEnding: {Code: smtpd.CodeMailboxUnavailablePerm, Msg: []string{"Bare LF"}}},
+	}
+	b, err := json.Marshal(replies)
+	assert.NoError(t, err, "")
+	assert.Equal(t, `{"hostname":"mx.example.com","replies":{"bareLineEnding":{"code":550,"message":["Bare LF"]}},"extensions":null}`, string(b), "")
+
+	parsed := smtpd.Replies{}
+	assert.NoError(t, json.Unmarshal(b, &parsed), "")
+	assert.Equal(t, replies, parsed, "")
+
+	assert.Error(t, json.Unmarshal([]byte(`{"replies":{"unknown":{"code":550}}}`), &parsed), "")
+}
+
+func TestDefaultReplies(t *testing.T) {
+	defaults := smtpd.DefaultReplies()
+	assert.NotEmpty(t, defaults.Hostname, "")
+	assert.Equal(t, smtpd.CodeReady, defaults.Responses[smtpd.Ready].Code, "")
+
+	defaults.Responses[smtpd.Ready] = smtpd.Response{Code: smtpd.CodeNotAvailable, Msg: []string{"Changed"}}
+	defaults.Responses[smtpd.Success].Msg[0] = "Changed"
+	assert.Equal(t, "220 <domain> Service ready", smtpd.DefaultReplies().Responses[smtpd.Ready].String(), "Defaults MUST NOT be modified")
+	assert.Equal(t, "250 OK", smtpd.DefaultReplies().Responses[smtpd.Success].String(), "Defaults MUST NOT be modified")
+}
+
+func TestParseReply(t *testing.T) {
+	res, err := smtpd.ParseReply("220 <domain> ESMTP\nWelcome")
+	assert.NoError(t, err, "")
+	assert.Equal(t, &smtpd.Response{Code: smtpd.CodeReady, Msg: []string{"<domain> ESMTP", "Welcome"}}, res, "")
+
+	res, err = smtpd.ParseReply("550")
+	assert.NoError(t, err, "")
+	assert.Nil(t, res.Msg, "")
+
+	for _, reply := range []string{"", "OK", "199 Too low", "600 Too high"} {
+		_, err = smtpd.ParseReply(reply)
+		assert.Error(t, err, reply)
+	}
+
+	resp, err := smtpd.ParseResp(strings.ToUpper("lineTooLong"))
+	assert.NoError(t, err, "")
+	assert.Equal(t, smtpd.LineTooLong, resp, "")
+}
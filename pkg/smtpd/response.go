@@ -37,6 +37,7 @@ package smtpd
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -49,6 +50,11 @@ type Response struct {
 	Msg  []string `json:"message"`
 }
 
+// Error returns the response as text, so a *Response can be returned as error by a Handler.
+func (e Response) Error() string {
+	return e.String()
+}
+
 // IsError returns true if the response is an error.
 func (e Response) IsError() bool {
 	return strings.HasPrefix(e.String(), "5")
@@ -93,6 +99,16 @@ const (
 	Status                            // Server status
 	Misconfiguration                  // Unable to reply because of misconfiguration
 	Extensions                        // Reply to EHLO with supported extensions
+	BareLineEnding                    // Data rejected because of bare CR or LF
+	LineTooLong                       // Data rejected because of a line longer than 1000 octets
+	AuthSucceeded                     // Client is authenticated
+	AuthInvalid                       // Credentials given with AUTH are invalid
+	AuthRequired                      // Client must authenticate before sending mail
+	AuthMechanism                     // Authentication mechanism is not supported
+	AuthCancelled                     // Client cancelled the authentication exchange
+	TLSReady                          // Client can start the TLS negotiation
+	XClientDenied                     // Client is not allowed to use XCLIENT or XFORWARD
+	XClientAttribute                  // Bad attribute given with XCLIENT or XFORWARD
 )
 
 // SMTP reply codes as defined by RFC 5321, 4.2.3
@@ -104,6 +120,8 @@ const (
 	CodeSuccess                 Code = 250 // Requested mail action okay, completed
 	CodeUserNotLocalTemp        Code = 251 // User not local; will forward to <forward-path>
 	CodeCannotVerify            Code = 252 // Cannot VRFY user, but will accept message and attempt delivery
+	CodeAuthSucceeded           Code = 235 // Authentication succeeded (RFC 4954)
+	CodeChallenge               Code = 334 // Server challenge of the authentication exchange (RFC 4954)
 	CodeAskForData              Code = 354 // Start mail input; end with <CRLF>.<CRLF>
 	CodeNotAvailable            Code = 421 // <domain> Service not available, closing transmission channel
 	CodeMailboxUnavailableTemp  Code = 450 // Requested mail action not taken: mailbox unavailable (e.g., mailbox busy or temporarily blocked for policy reasons)
@@ -119,12 +137,15 @@ const (
 	CodeUserNotLocalPerm        Code = 551 // User not local; please try <forward-path>
 	CodeInsufficientStoragePerm Code = 552 // Requested mail action aborted: exceeded storage allocation
 	CodeMailboxNotAllowed       Code = 553 // Requested action not taken: mailbox name not allowed (e.g., mailbox syntax incorrect)
+	CodeAuthRequired            Code = 530 // Authentication required (RFC 4954)
+	CodeAuthInvalid             Code = 535 // Authentication credentials invalid (RFC 4954)
 	CodeTransactionFailed       Code = 554 // Transaction failed
 	CodeMailFromRcptToParam     Code = 555 // MAIL FROM/RCPT TO parameters not recognized or not implemented
 )
 
-// Responses returned by the SMTP server
-var Responses = map[Resp]Response{
+// responses returned by the SMTP server, unless replaced by the Replies of the server. They are never changed,
+// DefaultReplies returns a copy.
+var responses = map[Resp]Response{
 	Ready:                 Response{CodeReady, []string{"<domain> Service ready"}},
 	Closing:               Response{CodeClosing, []string{"<domain> Service closing transmission channel"}},
 	Success:               Response{CodeSuccess, []string{"OK"}},
@@ -142,28 +163,104 @@ var Responses = map[Resp]Response{
 	Help:                  Response{CodeHelp, []string{""}},
 	Status:                Response{CodeStatus, []string{""}},
 	Extensions:            Response{CodeSuccess, []string{"<domain>", "HELP"}},
+	BareLineEnding:        Response{CodeTransactionFailed, []string{"Bare <CR> or <LF> received, lines must end with <CRLF>"}},
+	LineTooLong:           Response{CodeCommandUnrecognized, []string{"Line too long"}},
+	AuthSucceeded:         Response{CodeAuthSucceeded, []string{"2.7.0 Authentication successful"}},
+	AuthInvalid:           Response{CodeAuthInvalid, []string{"5.7.8 Authentication credentials invalid"}},
+	AuthRequired:          Response{CodeAuthRequired, []string{"5.7.0 Authentication required"}},
+	AuthMechanism:         Response{CodeParameterNotImplemented, []string{"5.5.4 Unrecognized authentication type"}},
+	AuthCancelled:         Response{CodeParameterSyntax, []string{"5.7.0 Authentication cancelled"}},
+	TLSReady:              Response{CodeReady, []string{"2.0.0 Ready to start TLS"}},
+	XClientDenied:         Response{CodeMailboxUnavailablePerm, []string{"5.7.0 Insufficient authorization"}},
+	XClientAttribute:      Response{CodeParameterSyntax, []string{"5.5.4 Bad attribute name or value"}},
+}
+
+// names of responses in the configuration and the REST API
+var respNames = map[Resp]string{
+	Ready:                 "ready",
+	Closing:               "closing",
+	Success:               "success",
+	Abort:                 "abort",
+	Data:                  "data",
+	NotAvailable:          "notAvailable",
+	ShuttingDown:          "shuttingDown",
+	SessionTimeout:        "sessionTimeout",
+	CommandUnrecognized:   "commandUnrecognized",
+	ParameterSyntax:       "parameterSyntax",
+	CommandNotImplemented: "commandNotImplemented",
+	BadSequence:           "badSequence",
+	NoValidRecipients:     "noValidRecipients",
+	Help:                  "help",
+	Status:                "status",
+	Misconfiguration:      "misconfiguration",
+	Extensions:            "extensions",
+	BareLineEnding:        "bareLineEnding",
+	LineTooLong:           "lineTooLong",
+	AuthSucceeded:         "authSucceeded",
+	AuthInvalid:           "authInvalid",
+	AuthRequired:          "authRequired",
+	AuthMechanism:         "authMechanism",
+	AuthCancelled:         "authCancelled",
+	TLSReady:              "tlsReady",
+	XClientDenied:         "xclientDenied",
+	XClientAttribute:      "xclientAttribute",
+}
+
+func (resp Resp) String() string {
+	if name, ok := respNames[resp]; ok {
+		return name
+	}
+	return fmt.Sprintf("Resp(%d)", uint16(resp))
+}
+
+// MarshalText returns the name of the response.
+func (resp Resp) MarshalText() ([]byte, error) {
+	if _, ok := respNames[resp]; !ok {
+		return nil, fmt.Errorf("unknown response %d", uint16(resp))
+	}
+	return []byte(resp.String()), nil
+}
+
+// UnmarshalText parses the name of a response.
+func (resp *Resp) UnmarshalText(text []byte) error {
+	parsed, err := ParseResp(string(text))
+	if err != nil {
+		return err
+	}
+	*resp = parsed
+	return nil
+}
+
+// ParseResp returns the response with the given name, case is ignored.
+func ParseResp(name string) (Resp, error) {
+	for resp, n := range respNames {
+		if strings.EqualFold(n, name) {
+			return resp, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown response %q", name)
 }
 
 var hostname string
 
-// SetReply set reply text of given code.
-func SetReply(resp Resp, s ...string) {
-	response := r(resp)
-	if len(s) > 0 && s[0] != "" {
-		response.Msg = s
+// ParseReply parses a reply given as "<code> <text>", lines of text are separated by "\n".
+// The text is empty if not given.
+func ParseReply(reply string) (*Response, error) {
+	parts := strings.SplitN(reply, " ", 2)
+	code, err := strconv.Atoi(parts[0])
+	if err != nil || code < 200 || code > 599 {
+		return nil, fmt.Errorf("invalid reply %q, expected format is \"<code> <text>\" with code between 200 and 599", reply)
 	}
-	for i, msg := range response.Msg {
-		response.Msg[i] = strings.ReplaceAll(msg, "<domain>", hostname)
+	response := &Response{Code(code), nil}
+	if len(parts) > 1 {
+		response.Msg = strings.Split(parts[1], "\n")
 	}
-	Responses[resp] = *response
+	return response, nil
 }
 
+// r returns the default response.
 func r(r Resp) *Response {
-	response, ok := Responses[r]
-	if !ok {
-		response = Responses[Misconfiguration]
-	}
-	return &response
+	return (*Replies)(nil).reply(r)
 }
 
 func init() {
@@ -171,7 +268,4 @@ func init() {
 	if hostname, err = os.Hostname(); err != nil {
 		hostname = "localhost"
 	}
-	for code, text := range Responses {
-		SetReply(code, text.Msg...)
-	}
 }
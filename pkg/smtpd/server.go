@@ -36,59 +36,205 @@
 package smtpd
 
 import (
+	"crypto/tls"
 	"net"
 	"net/textproto"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/adrienaury/mailmock/internal/log"
 )
 
+// ServerState is the state of a Server.
+type ServerState string
+
+// Server States
+const (
+	SVStarting  ServerState = "starting"  // server is not listening yet
+	SVListening ServerState = "listening" // server is accepting clients connection
+	SVDraining  ServerState = "draining"  // server is stopping, waiting for sessions to end
+	SVStopped   ServerState = "stopped"   // server is stopped or failed to start
+)
+
+// ServerStatus gives the state of a Server.
+type ServerStatus struct {
+	Name     string      `json:"name"`
+	Addr     string      `json:"addr"`
+	State    ServerState `json:"state"`
+	Sessions int         `json:"sessions"` // number of sessions in progress
+}
+
+// Options configures optional behaviours of the SMTP server.
+type Options struct {
+	TraceHeaders   bool // prepend Return-Path and Received trace header fields to received mails
+	MissingHeaders bool // add Message-ID and Date header fields to received mails if they are missing
+	StrictData     bool // reject data with bare CR or LF and lines longer than 1000 octets (RFC 5321)
+	LMTP           bool // serve LMTP (RFC 2033) instead of SMTP : LHLO instead of HELO/EHLO, one reply per recipient to DATA
+
+	AuthRequired  bool          // refuse MAIL until the client is authenticated with AUTH, like submission servers (RFC 6409)
+	Authenticator Authenticator // checks credentials given with AUTH, any credentials are accepted if nil
+	TLS           *tls.Config   // offer STARTTLS (RFC 3207) if not nil
+	ImplicitTLS   bool          // require TLS from the start of connections (RFC 8314), TLS must be set
+	Banner        []string      // text of the greeting reply instead of the Ready reply, "<domain>" is replaced by the host name
+
+	ProxyTrusted   []*net.IPNet // read a PROXY protocol header (version 1 or 2) from connections of these networks
+	XClientTrusted []*net.IPNet // accept XCLIENT and XFORWARD commands (Postfix extensions) from clients of these networks, and from any client of a Unix domain socket if not empty
+}
+
 // Server is holding the SMTP server properties.
 type Server struct {
 	name      string
-	host      string
-	port      string
+	network   string // "tcp" or "unix"
+	addr      string // host and port, or path of the socket
 	th        *TransactionHandler
+	sh        *SessionHandler
+	ch        *CommandHandler
+	chain     *Chain
+	options   Options
+	replies   Replies
 	logger    log.Logger
 	waitGroup *sync.WaitGroup
+	mutex     sync.RWMutex
+	status    ServerStatus
 }
 
 // NewServer creates a SMTP server.
 func NewServer(name string, host string, port string, th *TransactionHandler, logger log.Logger) *Server {
+	return newServer(name, "tcp", net.JoinHostPort(host, port), th, logger)
+}
+
+// NewUnixServer creates a SMTP server listening on a Unix domain socket.
+func NewUnixServer(name string, path string, th *TransactionHandler, logger log.Logger) *Server {
+	return newServer(name, "unix", path, th, logger)
+}
+
+func newServer(name string, network string, addr string, th *TransactionHandler, logger log.Logger) *Server {
 	if logger == nil {
 		logger = log.DefaultLogger
 	}
 	l := logger.WithFields(log.Fields{
 		log.FieldServer: name,
-		log.FieldListen: net.JoinHostPort(host, port),
+		log.FieldListen: addr,
 	})
-	srv := &Server{name, host, port, th, l, &sync.WaitGroup{}}
+	srv := &Server{
+		name:      name,
+		network:   network,
+		addr:      addr,
+		th:        th,
+		logger:    l,
+		waitGroup: &sync.WaitGroup{},
+		status:    ServerStatus{Name: name, Addr: addr, State: SVStarting},
+	}
 	return srv
 }
 
+// SetOptions sets the optional behaviours of the server.
+func (srv *Server) SetOptions(options Options) {
+	srv.options = options
+}
+
+// SetReplies sets what the server replies to clients, sessions in progress keep the previous replies.
+func (srv *Server) SetReplies(replies Replies) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.replies = replies.copy()
+}
+
+// Replies returns what the server replies to clients.
+func (srv *Server) Replies() Replies {
+	srv.mutex.RLock()
+	defer srv.mutex.RUnlock()
+	return srv.replies.copy()
+}
+
+// SetSessionHandler sets the handler called on each session event (connection, greeting, closing).
+func (srv *Server) SetSessionHandler(sh *SessionHandler) {
+	srv.sh = sh
+}
+
+// SetCommandHandler sets the handler called each time a command is processed.
+func (srv *Server) SetCommandHandler(ch *CommandHandler) {
+	srv.ch = ch
+}
+
+// SetChain sets the chain of handlers called for each completed or aborted transaction,
+// before the TransactionHandler. Handlers can be added to the chain while the server is running.
+func (srv *Server) SetChain(chain *Chain) {
+	srv.chain = chain
+}
+
 // ListenAndServe starts listening for clients connection and serves SMTP commands.
 func (srv *Server) ListenAndServe(stop <-chan struct{}) error {
 	defer srv.un(srv.trace("ListenAndServe"))
-	laddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(srv.host, srv.port))
-	if nil != err {
+	ln, err := srv.listen()
+	if err != nil {
 		srv.logger.Error("SMTP Server failed to start", log.Fields{log.FieldError: err})
+		srv.setState(SVStopped)
 		return err
 	}
-	ln, err := net.ListenTCP("tcp", laddr)
+	return srv.Serve(ln, stop)
+}
+
+// listen opens the listener of the server, a stale Unix domain socket is removed first.
+func (srv *Server) listen() (net.Listener, error) {
+	if srv.network == "unix" {
+		if fi, err := os.Stat(srv.addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(srv.addr); err != nil {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", srv.addr)
+	}
+	laddr, err := net.ResolveTCPAddr("tcp", srv.addr)
 	if err != nil {
-		srv.logger.Error("SMTP Server failed to start", log.Fields{log.FieldError: err})
-		return err
+		return nil, err
 	}
+	return net.ListenTCP("tcp", laddr)
+}
+
+// Serve accepts clients connection on the listener and serves SMTP commands, until stop is closed.
+// The listener is closed when Serve returns.
+func (srv *Server) Serve(ln net.Listener, stop <-chan struct{}) error {
+	srv.mutex.Lock()
+	srv.status.Addr = ln.Addr().String()
+	srv.status.State = SVListening
+	srv.mutex.Unlock()
 	srv.logger.Info("SMTP Server is listening")
 	srv.waitGroup.Add(1)
 	srv.serve(ln, stop)
+	srv.setState(SVDraining)
 	srv.waitGroup.Wait()
+	srv.setState(SVStopped)
 	srv.logger.Info("SMTP Server is stopped")
 	return nil
 }
 
-func (srv *Server) serve(ln *net.TCPListener, stop <-chan struct{}) {
+// Status returns the current state of the server.
+func (srv *Server) Status() ServerStatus {
+	srv.mutex.RLock()
+	defer srv.mutex.RUnlock()
+	return srv.status
+}
+
+func (srv *Server) setState(state ServerState) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.status.State = state
+}
+
+func (srv *Server) addSessions(delta int) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.status.Sessions += delta
+}
+
+// deadliner is implemented by TCP and Unix domain socket listeners.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+func (srv *Server) serve(ln net.Listener, stop <-chan struct{}) {
 	defer srv.un(srv.trace("serve"))
 	defer ln.Close()
 	defer srv.waitGroup.Done()
@@ -98,31 +244,65 @@ func (srv *Server) serve(ln *net.TCPListener, stop <-chan struct{}) {
 			return
 		default:
 		}
-		err := ln.SetDeadline(time.Now().Add(1e9)) // 1 second
-		if err != nil {
-			srv.logger.Error("SetDeadline on accept connection failed", log.Fields{log.FieldError: err})
+		if dl, ok := ln.(deadliner); ok {
+			if err := dl.SetDeadline(time.Now().Add(1e9)); err != nil { // 1 second
+				srv.logger.Error("SetDeadline on accept connection failed", log.Fields{log.FieldError: err})
+			}
 		}
 		conn, err := ln.Accept()
 		if err != nil {
 			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
 				continue
 			}
+			select {
+			case <-stop:
+				return // the listener was closed on purpose
+			default:
+			}
 			srv.logger.Error("SMTP Server failed to accept connection", log.Fields{log.FieldError: err})
 			continue
 		}
 		srv.waitGroup.Add(1)
-		go srv.handleConnection(conn.(*net.TCPConn), stop)
+		go srv.handleConnection(conn, stop)
 	}
 }
 
-func (srv *Server) handleConnection(conn *net.TCPConn, stop <-chan struct{}) {
+func (srv *Server) handleConnection(conn net.Conn, stop <-chan struct{}) {
 	defer srv.un(srv.trace("handleConnection"))
+	defer srv.waitGroup.Done()
+	proxyAddr := ""
+	if trusted(conn.RemoteAddr(), srv.options.ProxyTrusted) {
+		proxied, err := readProxyHeader(conn)
+		if err != nil {
+			srv.logger.Error("Invalid PROXY protocol header, closing connection", log.Fields{log.FieldError: err})
+			conn.Close()
+			return
+		}
+		proxyAddr = conn.RemoteAddr().String()
+		conn = proxied
+	}
+	if srv.options.ImplicitTLS && srv.options.TLS != nil {
+		conn = tls.Server(conn, srv.options.TLS)
+	}
 	tpc := textproto.NewConn(conn)
 	defer tpc.Close()
-	defer srv.waitGroup.Done()
+	srv.addSessions(1)
+	defer srv.addSessions(-1)
 
 	s := NewSession(tpc, srv.th, srv.logger)
-	s.tcpConn = conn
+	s.netConn = conn
+	s.sh = srv.sh
+	s.ch = srv.ch
+	s.chain = srv.chain
+	s.Options = srv.options
+	replies := srv.Replies()
+	s.replies = &replies
+	s.TLS = srv.options.ImplicitTLS && srv.options.TLS != nil
+	s.RemoteAddr = conn.RemoteAddr().String()
+	s.ProxyAddr = proxyAddr
+	s.xclientTrusted = trusted(conn.RemoteAddr(), srv.options.XClientTrusted) ||
+		srv.network == "unix" && len(srv.options.XClientTrusted) > 0
+	s.LocalAddr = conn.LocalAddr().String()
 	s.Serve(stop)
 }
 
@@ -35,10 +35,14 @@
 package smtpd
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/textproto"
+	"strings"
 	"time"
 
 	"github.com/adrienaury/mailmock/internal/log"
@@ -55,25 +59,74 @@ const (
 	SSClosed    SessionState = "closed"
 )
 
+// SessionEvent is an event occurring during the lifecycle of a Session.
+type SessionEvent string
+
+// Session Events
+const (
+	SEConnected SessionEvent = "connected" // client is connected and was greeted
+	SEHello     SessionEvent = "hello"     // client identified itself with HELO or EHLO
+	SEClosed    SessionEvent = "closed"    // session is over, connection will be closed
+)
+
 // TransactionHandler will be called each time a transaction reach TSCompleted or TSAborted status.
+//
+// It is kept for compatibility and called after the handlers of the Chain, new code should register a Handler
+// with Chain.UseAlways instead.
 type TransactionHandler func(*Transaction)
 
+// SessionHandler will be called each time a session event occurs.
+type SessionHandler func(*Session, SessionEvent)
+
+// CommandHandler will be called each time a command is processed, with the name of the command in uppercase,
+// the reply and the processing time (for DATA, it includes the reception of the content).
+type CommandHandler func(s *Session, name string, res *Response, duration time.Duration)
+
+// Reasons of session closing
+const (
+	CRQuit           = "quit"            // client sent QUIT command
+	CRConnectionLost = "connection lost" // client closed the connection
+	CRNetworkError   = "network error"   // failed to send a response to the client
+	CRTimeout        = "timeout"         // client was inactive for too long
+	CRShutdown       = "shutdown"        // server is shutting down
+)
+
 // Session represents a SMTP session of a client.
 type Session struct {
-	State    SessionState `json:"state"`
-	Client   string       `json:"client"`
-	Tr       *Transaction `json:"transaction"`
-	Extended bool
-	conn     *textproto.Conn
-	th       *TransactionHandler
-	logger   log.Logger
-	tcpConn  *net.TCPConn
-	mustStop bool
+	ID             string            `json:"id"`
+	State          SessionState      `json:"state"`
+	Client         string            `json:"client"`
+	Tr             *Transaction      `json:"transaction"`
+	Extended       bool              `json:"extended"`
+	TLS            bool              `json:"tls"`  // the connection is encrypted
+	User           string            `json:"user"` // login of the authenticated client
+	RemoteAddr     string            `json:"remoteAddr"`
+	ProxyAddr      string            `json:"proxyAddr"` // IP address and port of the proxy, if the client connected through a proxy
+	XClient        map[string]string `json:"xclient"`   // attributes of the client overridden with XCLIENT
+	LocalAddr      string            `json:"localAddr"`
+	Start          time.Time         `json:"start"`
+	End            time.Time         `json:"end"`
+	CloseReason    string            `json:"closeReason"`
+	Transcript     []string          `json:"transcript"`   // every line received (C:) and sent (S:)
+	Transactions   []*Transaction    `json:"transactions"` // completed or aborted transactions
+	Options        Options           `json:"-"`
+	conn           *textproto.Conn
+	th             *TransactionHandler
+	sh             *SessionHandler
+	ch             *CommandHandler
+	chain          *Chain
+	replies        *Replies // replies of the server when the session started, default replies if nil
+	logger         log.Logger
+	netConn        net.Conn
+	mustStop       bool
+	upgrade        bool              // TLS negotiation starts after the reply
+	xclientTrusted bool              // XCLIENT and XFORWARD are accepted from the client
+	forward        map[string]string // attributes given with XFORWARD for the next transaction
 }
 
 // NewSession return a new Session.
 func NewSession(c *textproto.Conn, th *TransactionHandler, logger log.Logger) *Session {
-	s := &Session{State: SSInitiated, conn: c, th: th, logger: nil}
+	s := &Session{ID: newID(), State: SSInitiated, Start: time.Now(), conn: c, th: th, logger: nil}
 	if logger == nil {
 		logger = log.DefaultLogger
 	}
@@ -85,39 +138,53 @@ func NewSession(c *textproto.Conn, th *TransactionHandler, logger log.Logger) *S
 	return s
 }
 
+// SetReplies sets what the session replies to the client, default replies are used otherwise.
+func (s *Session) SetReplies(replies Replies) {
+	replies = replies.copy()
+	s.replies = &replies
+}
+
 // Serve will reponds to any request until a QUIT command is received or connection is broken.
 func (s *Session) Serve(stop <-chan struct{}) {
 	if s.State == SSClosed {
 		s.logger.Warn("Cannot serve a closed session")
-		if err := s.conn.PrintfLine("%v", r(NotAvailable)); err != nil {
-			s.logger.Error("Failed to send response to client", log.Fields{log.FieldError: err, log.FieldResponse: r(NotAvailable)})
+		if err := s.reply(s.r(NotAvailable)); err != nil {
+			s.logger.Error("Failed to send response to client", log.Fields{log.FieldError: err, log.FieldResponse: s.r(NotAvailable)})
 		}
 		return
 	}
 
-	if err := s.conn.PrintfLine("%v", r(Ready)); err != nil {
-		s.logger.Error("Failed to send greeting message, quitting session", log.Fields{log.FieldError: err, log.FieldResponse: r(Ready)})
+	greeting := s.greeting()
+	if err := s.reply(greeting); err != nil {
+		s.logger.Error("Failed to send greeting message, quitting session", log.Fields{log.FieldError: err, log.FieldResponse: greeting})
+		s.close(CRNetworkError)
 		s.quit()
 		return
 	}
 
+	s.handleEvent(SEConnected)
+	defer s.release()
+	defer s.handleEvent(SEClosed)
+
 	shutdown := make(chan struct{})
 	defer close(shutdown)
 
+	conn := s.conn // the connection is replaced after a TLS negotiation, closing the first one closes the others
+	netConn, logger := s.netConn, s.logger
 	go func() {
 		// Block until either stop or shutdown signal
 		select {
 		case <-stop:
 			s.mustStop = true
-			s.logger.Warn("Server must stop, session will timeout in 30 seconds (at most)")
+			logger.Warn("Server must stop, session will timeout in 30 seconds (at most)")
 			<-time.After(30 * time.Second)
-			if s.tcpConn != nil {
-				_ = s.tcpConn.SetReadDeadline(time.Now())
+			if netConn != nil {
+				_ = netConn.SetReadDeadline(time.Now())
 			}
 		case <-shutdown:
 		}
 		<-time.After(5 * time.Second)
-		s.conn.Close()
+		conn.Close()
 	}()
 
 	s.serveLoop(stop)
@@ -127,10 +194,10 @@ func (s *Session) serveLoop(stop <-chan struct{}) {
 	for s.State != SSClosed {
 		var res *Response
 
-		if s.tcpConn != nil {
+		if s.netConn != nil {
 			// SMTP server SHOULD have a timeout of at least 5 minutes while it
 			// is awaiting the next command from the sender (RFC 5321 4.5.3.2.7.)
-			if err := s.tcpConn.SetReadDeadline(time.Now().Add(time.Minute * 5)); err != nil {
+			if err := s.netConn.SetReadDeadline(time.Now().Add(time.Minute * 5)); err != nil {
 				s.logger.Error("SetDeadline on SMTP session failed", log.Fields{log.FieldError: err})
 			}
 		}
@@ -140,27 +207,36 @@ func (s *Session) serveLoop(stop <-chan struct{}) {
 		switch {
 		case err == io.EOF || err == io.ErrClosedPipe:
 			s.logger.Error("Lost client connection, quitting", log.Fields{log.FieldError: err})
+			s.close(CRConnectionLost)
 			res = s.quit()
 		case ok && errop.Timeout():
 			if s.mustStop {
 				s.logger.Warn("Session interrupted because server is shutting down")
+				s.close(CRShutdown)
 			} else {
 				s.logger.Warn("Session timed out")
+				s.close(CRTimeout)
 			}
-			if err := s.conn.PrintfLine("%v", r(SessionTimeout)); err != nil {
-				s.logger.Error("Failed to send response to client", log.Fields{log.FieldError: err, log.FieldResponse: r(SessionTimeout)})
+			if err := s.reply(s.r(SessionTimeout)); err != nil {
+				s.logger.Error("Failed to send response to client", log.Fields{log.FieldError: err, log.FieldResponse: s.r(SessionTimeout)})
 			}
+			_ = s.Tr.Abort()
+			s.handleTransaction()
 			return
 		case err != nil:
 			s.logger.Error("Network error, requested action cannot be processed", log.Fields{log.FieldError: err})
-			res = r(Abort)
+			res = s.r(Abort)
 		default:
-			s.logger.Debug("Received command", log.Fields{log.FieldCommand: input})
+			command := redact(input)
+			s.logger.Debug("Received command", log.Fields{log.FieldCommand: command})
+			s.record("C: ", command)
+			start := time.Now()
 			res = s.receive(input)
+			s.handleCommand(input, res, time.Since(start))
 			if res.IsError() {
-				s.logger.Warn("Processed command", log.Fields{log.FieldCommand: input, log.FieldResponse: res})
+				s.logger.Warn("Processed command", log.Fields{log.FieldCommand: command, log.FieldResponse: res})
 			} else {
-				s.logger.Info("Processed command", log.Fields{log.FieldCommand: input, log.FieldResponse: res})
+				s.logger.Info("Processed command", log.Fields{log.FieldCommand: command, log.FieldResponse: res})
 			}
 		}
 
@@ -168,31 +244,58 @@ func (s *Session) serveLoop(stop <-chan struct{}) {
 		case <-stop:
 			// We need to shutdown
 			s.logger.Warn("Session interrupted because server is shutting down")
-			if err := s.conn.PrintfLine("%v", r(ShuttingDown)); err != nil {
-				s.logger.Error("Failed to send response to client", log.Fields{log.FieldError: err, log.FieldResponse: r(ShuttingDown)})
+			if err := s.reply(s.r(ShuttingDown)); err != nil {
+				s.logger.Error("Failed to send response to client", log.Fields{log.FieldError: err, log.FieldResponse: s.r(ShuttingDown)})
 			}
+			s.close(CRShutdown)
+			s.quit()
+			s.handleTransaction()
 			return
 		default:
 		}
 
-		if err := s.conn.PrintfLine("%v", res); err != nil {
+		if err := s.reply(res); err != nil {
 			s.logger.Error("Network error, failed to send response, quitting", log.Fields{log.FieldError: err, log.FieldResponse: res})
+			s.close(CRNetworkError)
 			s.quit()
+			s.handleTransaction()
 			return
 		}
 		s.handleTransaction()
+		if s.upgrade {
+			if err := s.upgradeTLS(); err != nil {
+				s.logger.Error("TLS negotiation failed, quitting", log.Fields{log.FieldError: err})
+				s.close(CRNetworkError)
+				return
+			}
+		}
+	}
+}
+
+// greeting returns the first reply of the session, the banner if set.
+func (s *Session) greeting() *Response {
+	if len(s.Options.Banner) == 0 {
+		return s.r(Ready)
+	}
+	greeting := &Response{CodeReady, make([]string, len(s.Options.Banner))}
+	for i, msg := range s.Options.Banner {
+		greeting.Msg[i] = strings.ReplaceAll(msg, "<domain>", s.replies.hostname())
 	}
+	return greeting
 }
 
 func (s *Session) receive(input string) (res *Response) {
-	cmd, res := ParseCommand(input)
+	cmd, res := parseCommand(input, s.replies)
 	if res != nil {
 		return res
 	}
+	if (cmd.Name == "LHLO") != s.Options.LMTP && (cmd.Name == "LHLO" || cmd.Name == "HELO" || cmd.Name == "EHLO") {
+		return s.r(CommandUnrecognized) // LMTP clients greet only with LHLO (RFC 2033 §4.1)
+	}
 	switch cmd.Name {
 	case "HELO":
 		res = s.hello(cmd.PositionalArgs[0], false)
-	case "EHLO":
+	case "EHLO", "LHLO":
 		res = s.hello(cmd.PositionalArgs[0], true)
 	case "MAIL":
 		res = s.mail(cmd)
@@ -210,6 +313,14 @@ func (s *Session) receive(input string) (res *Response) {
 		res = s.verify(cmd.PositionalArgs[0])
 	case "HELP":
 		res = s.help(cmd.PositionalArgs)
+	case "AUTH":
+		res = s.authenticate(cmd.PositionalArgs)
+	case "STARTTLS":
+		res = s.startTLS()
+	case "XCLIENT":
+		res = s.xclient(cmd.PositionalArgs)
+	case "XFORWARD":
+		res = s.xforward(cmd.PositionalArgs)
 	default:
 		s.logger.Error("Coding error, this should not happen")
 	}
@@ -217,24 +328,38 @@ func (s *Session) receive(input string) (res *Response) {
 }
 
 func (s *Session) hello(client string, extended bool) *Response {
-	s.Client = client
+	if _, ok := s.XClient["HELO"]; !ok {
+		s.Client = client
+	}
 	s.State = SSReady
 	if extended {
 		s.Extended = true
-		return r(Extensions)
 	}
-	return r(Success)
+	s.handleEvent(SEHello)
+	if extended {
+		return s.extensions()
+	}
+	return s.r(Success)
 }
 
 func (s *Session) mail(cmd *Command) *Response {
 	if s.State != SSReady {
-		return r(BadSequence)
+		return s.r(BadSequence)
+	}
+	if s.Options.AuthRequired && s.User == "" {
+		return s.r(AuthRequired)
 	}
 	s.Tr = NewTransaction()
+	s.Tr.Session = s.ID
+	s.Tr.Client = s.Client
+	s.Tr.RemoteAddr = s.RemoteAddr
+	s.Tr.User = s.User
+	s.Tr.replies = s.replies
+	s.Tr.Forwarded, s.forward = s.forward, nil
 	s.logger.Debug("Started transaction")
 	res, err := s.Tr.Process(cmd)
 	if err != nil {
-		return r(Abort)
+		return s.r(Abort)
 	}
 	s.State = SSBusy
 	return res
@@ -242,58 +367,91 @@ func (s *Session) mail(cmd *Command) *Response {
 
 func (s *Session) rcpt(cmd *Command) *Response {
 	if s.State != SSBusy {
-		return r(BadSequence)
+		return s.r(BadSequence)
 	}
 	res, err := s.Tr.Process(cmd)
 	if err != nil {
-		return r(Abort)
+		return s.r(Abort)
 	}
 	return res
 }
 
 func (s *Session) data(cmd *Command) *Response {
 	if s.State != SSBusy {
-		return r(BadSequence)
+		return s.r(BadSequence)
 	}
 	if len(s.Tr.Mail.Envelope.Recipients) == 0 {
-		return r(NoValidRecipients)
+		return s.r(NoValidRecipients)
 	}
 
 	res, err := s.Tr.Process(cmd)
 	if err != nil {
-		return r(Abort)
+		return s.r(Abort)
 	}
 
-	if err = s.conn.PrintfLine("%v", res); err != nil {
+	if err = s.reply(res); err != nil {
 		s.logger.Error("Failed to send response to client", log.Fields{log.FieldError: err, log.FieldResponse: res})
-		return r(Abort)
+		return s.r(Abort)
+	}
+	var data, violations []string
+	if s.Options.StrictData {
+		data, violations, err = readDataStrict(s.conn.R)
+	} else {
+		data, err = s.conn.ReadDotLines()
 	}
-	data, err := s.conn.ReadDotLines()
 	if err != nil {
-		return r(Abort)
+		return s.r(Abort)
+	}
+	s.record("C: ", data...)
+	s.record("C: ", ".")
+
+	s.Tr.Violations = violations
+	switch {
+	case hasViolation(violations, ViolationBareLF, ViolationBareCR):
+		res, err = s.Tr.Reject(data, s.r(BareLineEnding))
+	case hasViolation(violations, ViolationLineTooLong):
+		res, err = s.Tr.Reject(data, s.r(LineTooLong))
+	default:
+		res, err = s.Tr.Data(data)
 	}
-
-	res, err = s.Tr.Data(data)
 	if err != nil {
-		return r(Abort)
+		return s.r(Abort)
+	}
+	if s.Tr.State == TSCompleted {
+		s.addHeaders()
+		res = s.handleChain(res)
 	}
 
 	s.State = SSReady
+	if s.Options.LMTP {
+		return s.replyPerRecipient(res)
+	}
 	return res
 }
 
+// replyPerRecipient sends the reply to DATA for each recipient but the last, whose reply is returned (RFC 2033 §4.2).
+func (s *Session) replyPerRecipient(res *Response) *Response {
+	replies := s.Tr.perRecipient(res)
+	for _, reply := range replies[:len(replies)-1] {
+		if err := s.reply(reply); err != nil {
+			s.logger.Error("Failed to send response to client", log.Fields{log.FieldError: err, log.FieldResponse: reply})
+		}
+	}
+	return replies[len(replies)-1]
+}
+
 func (s *Session) verify(string) *Response {
-	return r(CommandNotImplemented)
+	return s.r(CommandNotImplemented)
 }
 
 func (s *Session) noop() *Response {
-	return r(Success)
+	return s.r(Success)
 }
 
 func (s *Session) reset() *Response {
 	err := s.Tr.Abort()
 	if err != nil {
-		return r(Abort)
+		return s.r(Abort)
 	}
 
 	if s.Client != "" {
@@ -302,32 +460,140 @@ func (s *Session) reset() *Response {
 		s.State = SSInitiated
 	}
 
-	return r(Success)
+	return s.r(Success)
 }
 
 func (s *Session) quit() *Response {
 	s.State = SSClosed
+	s.close(CRQuit)
 	_ = s.Tr.Abort()
-	return r(Closing)
+	return s.r(Closing)
+}
+
+// close records the end of the session, only the first reason is kept.
+func (s *Session) close(reason string) {
+	s.State = SSClosed
+	if s.CloseReason == "" {
+		s.CloseReason = reason
+		s.End = time.Now()
+	}
+}
+
+// release drops the connection and the logger of a closed session, the session record keeps only its data.
+func (s *Session) release() {
+	s.conn = nil
+	s.netConn = nil
+	s.logger = nil
+}
+
+// Size returns the size of the transcript in bytes, lines are counted with their CRLF terminator.
+func (s *Session) Size() int {
+	size := 0
+	for _, line := range s.Transcript {
+		size += len(line) + 2
+	}
+	return size
+}
+
+// reply sends the response to the client and records it in the transcript.
+func (s *Session) reply(res *Response) error {
+	s.record("S: ", strings.Split(res.String(), "\r\n")...)
+	return s.conn.PrintfLine("%v", res)
+}
+
+// redact hides the credentials given with the AUTH command, they are neither logged nor recorded.
+func redact(input string) string {
+	if fields := strings.Fields(input); len(fields) > 2 && strings.EqualFold(fields[0], "AUTH") {
+		return fields[0] + " " + fields[1] + " ***"
+	}
+	return input
+}
+
+// record appends lines to the transcript with the given prefix.
+func (s *Session) record(prefix string, lines ...string) {
+	for _, line := range lines {
+		s.Transcript = append(s.Transcript, prefix+line)
+	}
 }
 
 func (s *Session) help([]string) *Response {
 	if !s.Extended {
-		return r(CommandUnrecognized)
+		return s.r(CommandUnrecognized)
 	}
-	return r(Help)
+	return s.r(Help)
+}
+
+// handleChain calls the chain of handlers if it was not called yet for the current transaction, then the
+// TransactionHandler, kept for compatibility as a last handler. It returns the reply to send to the client.
+func (s *Session) handleChain(res *Response) *Response {
+	if s.Tr.chained {
+		return res
+	}
+	s.Tr.chained = true
+	if s.chain != nil {
+		s.chain.handle(s.Tr, func(err *HandlerError) {
+			res = s.refuse(res, err)
+		})
+	}
+	if s.th != nil && (*s.th) != nil {
+		(*s.th)(s.Tr)
+	}
+	return res
+}
+
+// refuse refuses the current transaction after the failure of a handler, and returns the reply to send to the client.
+func (s *Session) refuse(res *Response, err error) *Response {
+	var reply *Response
+	if !errors.As(err, &reply) {
+		reply = s.r(Abort)
+	}
+	if s.Tr.State != TSCompleted {
+		s.logger.Warn("Failed to handle transaction", log.Fields{log.FieldError: err})
+		return res
+	}
+	s.logger.Warn("Transaction refused by handler", log.Fields{log.FieldError: err, log.FieldResponse: reply})
+	return s.Tr.refuse(reply, err.Error())
 }
 
 func (s *Session) handleTransaction() {
 	if s.Tr != nil && (s.Tr.State == TSCompleted || s.Tr.State == TSAborted) {
 		s.logger.Debug("Ended transaction")
-		if s.th != nil && (*s.th) != nil {
-			(*s.th)(s.Tr)
-		}
+		s.handleChain(nil)
+		s.Transactions = append(s.Transactions, s.Tr)
 		s.Tr = nil
 	}
 }
 
+func (s *Session) handleEvent(e SessionEvent) {
+	if s.sh != nil && (*s.sh) != nil {
+		(*s.sh)(s, e)
+	}
+}
+
+func (s *Session) handleCommand(input string, res *Response, duration time.Duration) {
+	if s.ch != nil && (*s.ch) != nil {
+		name := ""
+		if fields := strings.Fields(input); len(fields) > 0 {
+			name = strings.ToUpper(fields[0])
+		}
+		(*s.ch)(s, name, res, duration)
+	}
+}
+
 func (s *Session) String() string {
-	return fmt.Sprintf("%p[%v]", s, s.State)
+	return fmt.Sprintf("%v[%v]", s.ID, s.State)
+}
+
+// newID returns a random identifier.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// r returns the response of the server.
+func (s *Session) r(resp Resp) *Response {
+	return s.replies.reply(resp)
 }
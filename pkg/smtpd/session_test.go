@@ -286,6 +286,90 @@ func TestClosedConnection(t *testing.T) {
 	test(t, snd, rcv)
 }
 
+func TestSessionRecord(t *testing.T) {
+	var (
+		snd string = strings.Join([]string{
+			"EHLO localhost",
+			"RCPT TO:<recipient@example.com>",
+			"MAIL FROM:<sender@example.com>",
+			"RCPT TO:<recipient@example.com>",
+			"DATA",
+			"Subject: Test",
+			"",
+			"This is a test",
+			".",
+			"MAIL FROM:<sender@example.com>",
+			"QUIT",
+		}, "\r\n")
+		rcv string = strings.Join([]string{
+			"220 Service ready",
+			"250 OK (extended)",
+			"503 Bad sequence of commands",
+			"250 OK",
+			"250 OK",
+			"354 Start mail input; end with <CRLF>.<CRLF>",
+			"250 OK",
+			"250 OK",
+			"221 Service closing transmission channel",
+			"",
+		}, "\r\n")
+	)
+	s, _ := test(t, snd, rcv)
+
+	assert.NotEmpty(t, s.ID, "Sessions MUST have an ID")
+	assert.Equal(t, "localhost", s.Client, "")
+	assert.Equal(t, smtpd.CRQuit, s.CloseReason, "")
+	assert.False(t, s.End.Before(s.Start), "")
+	assert.Len(t, s.Transactions, 2, "Sessions MUST keep track of their transactions")
+	assert.Equal(t, smtpd.TSCompleted, s.Transactions[0].State, "")
+	assert.Equal(t, smtpd.TSAborted, s.Transactions[1].State, "")
+	assert.Equal(t, s.ID, s.Transactions[0].Session, "Transactions MUST be linked to their session")
+	assert.Equal(t, "localhost", s.Transactions[0].Client, "")
+	assert.Equal(t, []string{
+		"S: 220 Service ready",
+		"C: EHLO localhost",
+		"S: 250 OK (extended)",
+		"C: RCPT TO:<recipient@example.com>",
+		"S: 503 Bad sequence of commands",
+		"C: MAIL FROM:<sender@example.com>",
+		"S: 250 OK",
+		"C: RCPT TO:<recipient@example.com>",
+		"S: 250 OK",
+		"C: DATA",
+		"S: 354 Start mail input; end with <CRLF>.<CRLF>",
+		"C: Subject: Test",
+		"C: ",
+		"C: This is a test",
+		"C: .",
+		"S: 250 OK",
+		"C: MAIL FROM:<sender@example.com>",
+		"S: 250 OK",
+		"C: QUIT",
+		"S: 221 Service closing transmission channel",
+	}, s.Transcript, "Sessions MUST record a full transcript")
+}
+
+func TestSessionConnectionLost(t *testing.T) {
+	var (
+		snd string = strings.Join([]string{
+			"HELO localhost",
+			"MAIL FROM:<sender@example.com>",
+		}, "\r\n")
+		rcv string = strings.Join([]string{
+			"220 Service ready",
+			"250 OK",
+			"250 OK",
+			"221 Service closing transmission channel",
+			"",
+		}, "\r\n")
+	)
+	s, _ := test(t, snd, rcv)
+
+	assert.Equal(t, smtpd.CRConnectionLost, s.CloseReason, "")
+	assert.Len(t, s.Transactions, 1, "")
+	assert.Equal(t, smtpd.TSAborted, s.Transactions[0].State, "")
+}
+
 func test(t *testing.T, snd string, rcv string) (s *smtpd.Session, rwc *MockConn) {
 	sndbuf := bytes.NewBuffer([]byte(snd))
 	rcvbuf := bytes.NewBuffer(nil)
@@ -296,6 +380,7 @@ func test(t *testing.T, snd string, rcv string) (s *smtpd.Session, rwc *MockConn
 
 	s = smtpd.NewSession(c, nil, nil)
 	assert.NotNil(t, s, "")
+	s.SetReplies(replies)
 
 	s.Serve(make(chan struct{}, 1))
 
@@ -325,3 +410,10 @@ func TestEOFConnection(t *testing.T) {
 		"",
 	}, "\r\n"), string(responses), "")
 }
+
+func TestSessionSize(t *testing.T) {
+	s, _ := test(t, "QUIT", "220 Service ready\r\n221 Service closing transmission channel\r\n")
+
+	assert.Len(t, s.Transcript, 3, "")
+	assert.Equal(t, 76, s.Size(), "Size of sessions MUST count their transcript, with CRLF line terminators")
+}
@@ -35,7 +35,10 @@
 package smtpd
 
 import (
+	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 )
 
 // TransactionState is the state of a Transaction.
@@ -50,11 +53,65 @@ const (
 	TSAborted    TransactionState = "aborted"      // the transaction is not complete
 )
 
+// HistoryEntry is a line received or sent during a transaction.
+type HistoryEntry struct {
+	Time time.Time `json:"time"`
+	Line string    `json:"line"`
+}
+
+// Relay is the outcome of the forwarding of the mail to another server.
+type Relay struct {
+	Time       time.Time `json:"time"`
+	Server     string    `json:"server"`     // address of the server
+	Recipients []string  `json:"recipients"` // recipients forwarded to the server
+	Error      string    `json:"error"`      // empty if the server accepted the mail
+}
+
 // Transaction represents either a successful, ongoing or aborted SMTP transaction.
 type Transaction struct {
-	Mail    Mail             `json:"mail"`
-	State   TransactionState `json:"state"`
-	History []string         `json:"history"`
+	Mail       Mail              `json:"mail"`
+	State      TransactionState  `json:"state"`
+	History    []HistoryEntry    `json:"history"`
+	Received   time.Time         `json:"received"`   // time the transaction was completed or aborted
+	Session    string            `json:"session"`    // ID of the session
+	Client     string            `json:"client"`     // name given by the client with HELO or EHLO
+	RemoteAddr string            `json:"remoteAddr"` // IP address and port of the client
+	User       string            `json:"user"`       // login of the authenticated client
+	Violations []string          `json:"violations"` // protocol violations of the client
+	Refusal    string            `json:"refusal"`    // reason of the refusal of the data by a handler, if any
+	Relays     []Relay           `json:"relays"`     // outcomes of the forwarding of the mail to other servers
+	Forwarded  map[string]string `json:"forwarded"`  // attributes of the original client given with XFORWARD
+	chained    bool              // the chain of handlers was called
+	replies    *Replies          // replies of the server, default replies if nil
+}
+
+// relayMutex guards the outcomes of the forwarding of mails, recorded once transactions are ended.
+var relayMutex sync.RWMutex
+
+// AddRelay records the outcome of the forwarding of the mail, it can be called while the transaction is read.
+func (tr *Transaction) AddRelay(relay Relay) {
+	relayMutex.Lock()
+	defer relayMutex.Unlock()
+	tr.Relays = append(tr.Relays, relay)
+}
+
+// GetRelays returns a copy of the outcomes of the forwarding of the mail.
+func (tr *Transaction) GetRelays() []Relay {
+	relayMutex.RLock()
+	defer relayMutex.RUnlock()
+	if tr.Relays == nil {
+		return nil
+	}
+	return append([]Relay{}, tr.Relays...)
+}
+
+// MarshalJSON encodes the transaction while outcomes of the forwarding of the mail can be added.
+func (tr *Transaction) MarshalJSON() ([]byte, error) {
+	type transaction Transaction
+	return json.Marshal(&struct {
+		*transaction
+		Relays []Relay `json:"relays"`
+	}{(*transaction)(tr), tr.GetRelays()})
 }
 
 // NewTransaction creates a new SMTP transaction with initial state set to TSInitiated.
@@ -65,12 +122,12 @@ func NewTransaction() *Transaction {
 // Process reads the given command, updates the transaction and returns appropriate response.
 func (tr *Transaction) Process(cmd *Command) (*Response, error) {
 	if tr != nil {
-		tr.History = append(tr.History, cmd.FullCmd)
+		tr.record(cmd.FullCmd)
 		r, err := tr.handleCommand(cmd)
 		if err != nil {
 			tr.History = tr.History[0 : len(tr.History)-1]
 		} else {
-			tr.History = append(tr.History, r.String())
+			tr.record(r.String())
 		}
 		return r, err
 	}
@@ -80,21 +137,63 @@ func (tr *Transaction) Process(cmd *Command) (*Response, error) {
 // Data sets full data, this method can only be user during TSData phase.
 func (tr *Transaction) Data(data []string) (*Response, error) {
 	if tr != nil && tr.State == TSData {
-		tr.History = append(tr.History, data...)
+		tr.record(data...)
 		tr.Mail.Content = data
 		tr.State = TSCompleted
-		r := r(Success)
-		tr.History = append(tr.History, ".")
-		tr.History = append(tr.History, r.String())
+		r := tr.replies.reply(Success)
+		tr.record(".", r.String())
+		tr.Received = time.Now()
 		return r, nil
 	}
 	return nil, fmt.Errorf("No transaction available to process data")
 }
 
+// Reject sets full data but refuses it with the given response, the transaction is aborted.
+// This method can only be used during TSData phase.
+func (tr *Transaction) Reject(data []string, res *Response) (*Response, error) {
+	if tr != nil && tr.State == TSData {
+		tr.record(data...)
+		tr.Mail.Content = data
+		tr.State = TSAborted
+		tr.record(".", res.String())
+		tr.Received = time.Now()
+		return res, nil
+	}
+	return nil, fmt.Errorf("No transaction available to reject data")
+}
+
+// refuse replaces the reply to the data of a completed transaction, the transaction is aborted.
+func (tr *Transaction) refuse(res *Response, reason string) *Response {
+	tr.State = TSAborted
+	tr.Refusal = reason
+	if n := len(tr.History); n > 0 {
+		tr.History[n-1].Line = res.String()
+	}
+	return res
+}
+
+// perRecipient returns the reply to the data for each recipient, they replace the reply in the history.
+func (tr *Transaction) perRecipient(res *Response) []*Response {
+	replies := make([]*Response, 0, len(tr.Mail.Envelope.Recipients))
+	last := HistoryEntry{Time: time.Now()}
+	if n := len(tr.History); n > 0 {
+		last = tr.History[n-1]
+		tr.History = tr.History[:n-1]
+	}
+	for _, rcpt := range tr.Mail.Envelope.Recipients {
+		reply := &Response{Code: res.Code, Msg: append([]string{}, res.Msg...)}
+		reply.Msg[len(reply.Msg)-1] = rcpt + " " + reply.Msg[len(reply.Msg)-1]
+		replies = append(replies, reply)
+		tr.History = append(tr.History, HistoryEntry{last.Time, reply.String()})
+	}
+	return replies
+}
+
 // Abort sets transaction's state to TSAborted.
 func (tr *Transaction) Abort() error {
 	if tr != nil && (tr.State == TSInitiated || tr.State == TSInProgress || tr.State == TSData) {
 		tr.State = TSAborted
+		tr.Received = time.Now()
 		return nil
 	}
 	if tr != nil && tr.State == TSCompleted {
@@ -110,6 +209,14 @@ func (tr *Transaction) Abort() error {
 	return nil
 }
 
+// record appends lines to the history.
+func (tr *Transaction) record(lines ...string) {
+	now := time.Now()
+	for _, line := range lines {
+		tr.History = append(tr.History, HistoryEntry{now, line})
+	}
+}
+
 func (tr *Transaction) handleCommand(cmd *Command) (*Response, error) {
 	switch tr.State {
 	case TSInitiated:
@@ -130,23 +237,23 @@ func (tr *Transaction) handleCommandInitiated(cmd *Command) (*Response, error) {
 	if cmd.Name == "MAIL" {
 		tr.Mail.Envelope.Sender = cmd.NamedArgs["FROM"]
 		tr.State = TSInProgress
-		return r(Success), nil
+		return tr.replies.reply(Success), nil
 	}
-	return r(BadSequence), nil
+	return tr.replies.reply(BadSequence), nil
 }
 
 func (tr *Transaction) handleCommandInProgress(cmd *Command) (*Response, error) {
 	switch cmd.Name {
 	case "RCPT":
 		tr.Mail.Envelope.Recipients = append(tr.Mail.Envelope.Recipients, cmd.NamedArgs["TO"])
-		return r(Success), nil
+		return tr.replies.reply(Success), nil
 	case "DATA":
 		if len(tr.Mail.Envelope.Recipients) > 0 {
 			tr.State = TSData
-			return r(Data), nil
+			return tr.replies.reply(Data), nil
 		}
 	}
-	return r(BadSequence), nil
+	return tr.replies.reply(BadSequence), nil
 }
 
 func (tr *Transaction) handleCommandCompleted(*Command) (*Response, error) {
@@ -157,6 +264,11 @@ func (tr *Transaction) handleCommandAborted(*Command) (*Response, error) {
 	return nil, fmt.Errorf("Sorry, this transaction is aborted ans doen't accept any command")
 }
 
+// Size returns the size of the mail in bytes.
+func (tr *Transaction) Size() int {
+	return tr.Mail.Size()
+}
+
 func (tr Transaction) String() string {
 	return fmt.Sprintf("Transaction %v [%p]", tr.State, &tr)
 }
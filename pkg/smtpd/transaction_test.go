@@ -38,6 +38,7 @@ package smtpd_test
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/adrienaury/mailmock/pkg/smtpd"
 	"github.com/stretchr/testify/assert"
@@ -54,7 +55,7 @@ var (
 func TestTransactionNominal(t *testing.T) {
 	tr := smtpd.NewTransaction()
 	assert.Equal(t, smtpd.TSInitiated, tr.State, "A newly created transaction MUST have an initiated State")
-	assert.Empty(t, tr.History, "A newly created transaction MUST have an empty History")
+	assert.Empty(t, lines(tr.History), "A newly created transaction MUST have an empty History")
 	assert.Empty(t, tr.Mail, "A newly created transaction MUST have an empty Mail")
 
 	res, err := tr.Process(&MailCommand)
@@ -62,7 +63,7 @@ func TestTransactionNominal(t *testing.T) {
 	assert.NotNil(t, res, "Initiated transactions MUST return a response to a well-formed MAIL command")
 	assert.Equal(t, smtpd.CodeSuccess, res.Code, "Initiated transactions MUST return response code 250 to a well-formed MAIL command")
 	assert.Equal(t, smtpd.TSInProgress, tr.State, "Initiated transactions MUST mutate to in progress state after a well-formed MAIL command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK"}, tr.History, "Initiated transactions MUST update their history after a well-formed MAIL command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK"}, lines(tr.History), "Initiated transactions MUST update their history after a well-formed MAIL command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com"}}, tr.Mail, "Initiated transactions MUST update the sender property after a well-formed MAIL command")
 
 	res, err = tr.Process(&RcptCommand)
@@ -70,7 +71,7 @@ func TestTransactionNominal(t *testing.T) {
 	assert.NotNil(t, res, "In progress transactions MUST return a response to a well-formed RCPT command")
 	assert.Equal(t, smtpd.CodeSuccess, res.Code, "In progress transactions MUST return response code 250 to a well-formed RCPT command")
 	assert.Equal(t, smtpd.TSInProgress, tr.State, "In progress transactions MUST NOT mutate state after a well-formed RCPT command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK"}, tr.History, "In progress transactions MUST update their history after a well-formed RCPT command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK"}, lines(tr.History), "In progress transactions MUST update their history after a well-formed RCPT command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}}, tr.Mail, "In progress transactions MUST update the recipient property after a well-formed RCPT command")
 
 	res, err = tr.Process(&DataCommand)
@@ -78,7 +79,7 @@ func TestTransactionNominal(t *testing.T) {
 	assert.NotNil(t, res, "In progress transactions MUST return a response to a well-formed DATA command")
 	assert.Equal(t, smtpd.CodeAskForData, res.Code, "In progress transactions MUST return response code 250 to a well-formed DATA command")
 	assert.Equal(t, smtpd.TSData, tr.State, "Initiated transactions MUST mutate to data state after a well-formed DATA command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, tr.History, "In progress transactions MUST update their history after a well-formed DATA command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, lines(tr.History), "In progress transactions MUST update their history after a well-formed DATA command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}}, tr.Mail, "In progress transactions MUST NOT update the mail after a well-formed DATA command")
 
 	res, err = tr.Data(MailData)
@@ -86,48 +87,48 @@ func TestTransactionNominal(t *testing.T) {
 	assert.NotNil(t, res, "Data transactions MUST return a response after a successful data transfer")
 	assert.Equal(t, smtpd.CodeSuccess, res.Code, "Data transactions MUST return response code 250 to a well-formed data transfer")
 	assert.Equal(t, smtpd.TSCompleted, tr.State, "Data transactions MUST mutate to completed state after a well-formed data transfer")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, tr.History, "Data transactions MUST update their history after a well-formed data transfer")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, lines(tr.History), "Data transactions MUST update their history after a well-formed data transfer")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}, Content: MailData}, tr.Mail, "Data transactions MUST NOT update the mail content after a well-formed data transfer")
 
 	res, err = tr.Process(&OtherCommand)
 	assert.Error(t, err, "Completed transactions MUST return an error after any unknown command")
 	assert.Nil(t, res, "Completed transactions MUST NOT return a response to any unknown command")
 	assert.Equal(t, smtpd.TSCompleted, tr.State, "Completed transactions MUST NOT mutate state after any unknown command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, tr.History, "Completed transactions MUST NOT update their history after any unknown command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, lines(tr.History), "Completed transactions MUST NOT update their history after any unknown command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}, Content: MailData}, tr.Mail, "Completed transactions MUST NOT update the mail after any unknown command")
 
 	res, err = tr.Process(&MailCommand)
 	assert.Error(t, err, "Completed transactions MUST return an error after a well-formed MAIL command")
 	assert.Nil(t, res, "Completed transactions MUST NOT return a response to a well-formed MAIL command")
 	assert.Equal(t, smtpd.TSCompleted, tr.State, "Completed transactions MUST NOT mutate state after a well-formed MAIL command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, tr.History, "Completed transactions MUST NOT update their history after a well-formed MAIL command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, lines(tr.History), "Completed transactions MUST NOT update their history after a well-formed MAIL command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}, Content: MailData}, tr.Mail, "Completed transactions MUST NOT update the mail after a well-formed MAIL command")
 
 	res, err = tr.Process(&RcptCommand)
 	assert.Error(t, err, "Completed transactions MUST return an error after a well-formed RCPT command")
 	assert.Nil(t, res, "Completed transactions MUST NOT return a response to a well-formed RCPT command")
 	assert.Equal(t, smtpd.TSCompleted, tr.State, "Completed transactions MUST NOT mutate state after a well-formed RCPT command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, tr.History, "Completed transactions MUST NOT update their history after a well-formed RCPT command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, lines(tr.History), "Completed transactions MUST NOT update their history after a well-formed RCPT command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}, Content: MailData}, tr.Mail, "Completed transactions MUST NOT update the mail after a well-formed RCPT command")
 
 	res, err = tr.Process(&DataCommand)
 	assert.Error(t, err, "Completed transactions MUST return an error after a well-formed DATA command")
 	assert.Nil(t, res, "Completed transactions MUST NOT return a response to a well-formed DATA command")
 	assert.Equal(t, smtpd.TSCompleted, tr.State, "Completed transactions MUST NOT mutate state after a well-formed DATA command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, tr.History, "Completed transactions MUST NOT update their history after a well-formed DATA command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, lines(tr.History), "Completed transactions MUST NOT update their history after a well-formed DATA command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}, Content: MailData}, tr.Mail, "Completed transactions MUST NOT update the mail after a well-formed DATA command")
 
 	res, err = tr.Data(MailData)
 	assert.Error(t, err, "Completed transactions MUST return an error after an attempt to transfer data")
 	assert.Nil(t, res, "Completed transactions MUST NOT return a response to an attempt to transfer data")
 	assert.Equal(t, smtpd.TSCompleted, tr.State, "Completed transactions MUST NOT mutate state after an attempt to transfer data")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, tr.History, "Completed transactions MUST NOT update their history after an attempt to transfer data")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, lines(tr.History), "Completed transactions MUST NOT update their history after an attempt to transfer data")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}, Content: MailData}, tr.Mail, "Completed transactions MUST NOT update the mail after an attempt to transfer data")
 
 	err = tr.Abort()
 	assert.Error(t, err, "Completed transactions MUST return an error after an attempt to abort transaction")
 	assert.Equal(t, smtpd.TSCompleted, tr.State, "Completed transactions MUST NOT mutate state after an attempt to abort transaction")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, tr.History, "Completed transactions MUST NOT update their history after an attempt to abort transaction")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>", MailData[0], MailData[1], MailData[2], ".", "250 OK"}, lines(tr.History), "Completed transactions MUST NOT update their history after an attempt to abort transaction")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}, Content: MailData}, tr.Mail, "Completed transactions MUST NOT update the mail after an attempt to abort transaction")
 
 	fmt.Println(tr)
@@ -139,48 +140,48 @@ func TestTransactionAbort1(t *testing.T) {
 	err := tr.Abort()
 	assert.NoError(t, err, "Initiated transactions MUST NOT return an error after an attempt to abort transaction")
 	assert.Equal(t, smtpd.TSAborted, tr.State, "Initiated transactions MUST mutate to aborted state after an attempt to abort transaction")
-	assert.Empty(t, tr.History, "Initiated transactions MUST NOT update their history after an attempt to abort transaction")
+	assert.Empty(t, lines(tr.History), "Initiated transactions MUST NOT update their history after an attempt to abort transaction")
 	assert.Empty(t, tr.Mail, "Initiated transactions MUST NOT update the mail after an attempt to abort transaction")
 
 	res, err := tr.Process(&OtherCommand)
 	assert.Error(t, err, "Aborted transactions MUST return an error after any unknown command")
 	assert.Nil(t, res, "Aborted transactions MUST NOT return a response to any unknown command")
 	assert.Equal(t, smtpd.TSAborted, tr.State, "Aborted transactions MUST NOT mutate state after any unknown command")
-	assert.Empty(t, tr.History, "Aborted transactions MUST NOT update their history after any unknown command")
+	assert.Empty(t, lines(tr.History), "Aborted transactions MUST NOT update their history after any unknown command")
 	assert.Empty(t, tr.Mail, "Aborted transactions MUST NOT update the mail after any unknown command")
 
 	res, err = tr.Process(&MailCommand)
 	assert.Error(t, err, "Aborted transactions MUST return an error after a well-formed MAIL command")
 	assert.Nil(t, res, "Aborted transactions MUST NOT return a response to a well-formed MAIL command")
 	assert.Equal(t, smtpd.TSAborted, tr.State, "Aborted transactions MUST NOT mutate state after a well-formed MAIL command")
-	assert.Empty(t, tr.History, "Aborted transactions MUST NOT update their history after a well-formed MAIL command")
+	assert.Empty(t, lines(tr.History), "Aborted transactions MUST NOT update their history after a well-formed MAIL command")
 	assert.Empty(t, tr.Mail, "Aborted transactions MUST NOT update the mail after a well-formed MAIL command")
 
 	res, err = tr.Process(&RcptCommand)
 	assert.Error(t, err, "Aborted transactions MUST return an error after a well-formed RCPT command")
 	assert.Nil(t, res, "Aborted transactions MUST NOT return a response to a well-formed RCPT command")
 	assert.Equal(t, smtpd.TSAborted, tr.State, "Aborted transactions MUST NOT mutate state after a well-formed RCPT command")
-	assert.Empty(t, tr.History, "Aborted transactions MUST NOT update their history after a well-formed RCPT command")
+	assert.Empty(t, lines(tr.History), "Aborted transactions MUST NOT update their history after a well-formed RCPT command")
 	assert.Empty(t, tr.Mail, "Aborted transactions MUST NOT update the mail after a well-formed RCPT command")
 
 	res, err = tr.Process(&DataCommand)
 	assert.Error(t, err, "Aborted transactions MUST return an error after a well-formed DATA command")
 	assert.Nil(t, res, "Aborted transactions MUST NOT return a response to a well-formed DATA command")
 	assert.Equal(t, smtpd.TSAborted, tr.State, "Aborted transactions MUST NOT mutate state after a well-formed DATA command")
-	assert.Empty(t, tr.History, "Aborted transactions MUST NOT update their history after a well-formed DATA command")
+	assert.Empty(t, lines(tr.History), "Aborted transactions MUST NOT update their history after a well-formed DATA command")
 	assert.Empty(t, tr.Mail, "Aborted transactions MUST NOT update the mail after a well-formed DATA command")
 
 	res, err = tr.Data(MailData)
 	assert.Error(t, err, "Aborted transactions MUST return an error after an attempt to transfer data")
 	assert.Nil(t, res, "Aborted transactions MUST NOT return a response to an attempt to transfer data")
 	assert.Equal(t, smtpd.TSAborted, tr.State, "Aborted transactions MUST NOT mutate state after an attempt to transfer data")
-	assert.Empty(t, tr.History, "Aborted transactions MUST NOT update their history after an attempt to transfer data")
+	assert.Empty(t, lines(tr.History), "Aborted transactions MUST NOT update their history after an attempt to transfer data")
 	assert.Empty(t, tr.Mail, "Aborted transactions MUST NOT update the mail after an attempt to transfer data")
 
 	err = tr.Abort()
 	assert.NoError(t, err, "Aborted transactions MUST return an error after an attempt to abort transaction")
 	assert.Equal(t, smtpd.TSAborted, tr.State, "Aborted transactions MUST NOT mutate state after an attempt to abort transaction")
-	assert.Empty(t, tr.History, "Aborted transactions MUST NOT update their history after an attempt to abort transaction")
+	assert.Empty(t, lines(tr.History), "Aborted transactions MUST NOT update their history after an attempt to abort transaction")
 	assert.Empty(t, tr.Mail, "Aborted transactions MUST NOT update the mail after an attempt to abort transaction")
 
 	fmt.Println(tr)
@@ -195,7 +196,7 @@ func TestTransactionAbort2(t *testing.T) {
 	err = tr.Abort()
 	assert.NoError(t, err, "In progress transactions MUST NOT return an error after an attempt to abort transaction")
 	assert.Equal(t, smtpd.TSAborted, tr.State, "In progress transactions MUST mutate to aborted state after an attempt to abort transaction")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK"}, tr.History, "In progress transactions MUST NOT update their history after an attempt to abort transaction")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK"}, lines(tr.History), "In progress transactions MUST NOT update their history after an attempt to abort transaction")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com"}}, tr.Mail, "In progress transactions MUST NOT update the mail after an attempt to abort transaction")
 
 	fmt.Println(tr)
@@ -216,7 +217,7 @@ func TestTransactionAbort3(t *testing.T) {
 	err = tr.Abort()
 	assert.NoError(t, err, "Data transactions MUST NOT return an error after an attempt to abort transaction")
 	assert.Equal(t, smtpd.TSAborted, tr.State, "Data transactions MUST mutate to aborted state after an attempt to abort transaction")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, tr.History, "Data transactions MUST NOT update their history after an attempt to abort transaction")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, lines(tr.History), "Data transactions MUST NOT update their history after an attempt to abort transaction")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}}, tr.Mail, "Data transactions MUST NOT update the mail after an attempt to abort transaction")
 
 	fmt.Println(tr)
@@ -230,7 +231,7 @@ func TestTransactionWrongSequence1(t *testing.T) {
 	assert.NotNil(t, res, "Initiated transactions MUST return a response to a well-formed RCPT command")
 	assert.Equal(t, smtpd.CodeBadSequence, res.Code, "Initiated transactions MUST return response code 503 to a well-formed RCPT command")
 	assert.Equal(t, smtpd.TSInitiated, tr.State, "Initiated transactions MUST NOT mutate state after a well-formed RCPT command")
-	assert.Equal(t, []string{RcptCommand.FullCmd, "503 Bad sequence of commands"}, tr.History, "Initiated transactions MUST update their history after a well-formed RCPT command")
+	assert.Equal(t, []string{RcptCommand.FullCmd, "503 Bad sequence of commands"}, lines(tr.History), "Initiated transactions MUST update their history after a well-formed RCPT command")
 	assert.Empty(t, tr.Mail, "Initiated transactions MUST NOT update the mail after a well-formed RCPT command")
 
 	res, err = tr.Process(&DataCommand)
@@ -238,7 +239,7 @@ func TestTransactionWrongSequence1(t *testing.T) {
 	assert.NotNil(t, res, "Initiated transactions MUST return a response to a well-formed DATA command")
 	assert.Equal(t, smtpd.CodeBadSequence, res.Code, "Initiated transactions MUST return response code 503 to a well-formed DATA command")
 	assert.Equal(t, smtpd.TSInitiated, tr.State, "Initiated transactions MUST NOT mutate state after a well-formed DATA command")
-	assert.Equal(t, []string{RcptCommand.FullCmd, "503 Bad sequence of commands", DataCommand.FullCmd, "503 Bad sequence of commands"}, tr.History, "Initiated transactions MUST update their history after a well-formed DATA command")
+	assert.Equal(t, []string{RcptCommand.FullCmd, "503 Bad sequence of commands", DataCommand.FullCmd, "503 Bad sequence of commands"}, lines(tr.History), "Initiated transactions MUST update their history after a well-formed DATA command")
 	assert.Empty(t, tr.Mail, "Initiated transactions MUST NOT update the mail after a well-formed DATA command")
 
 	fmt.Println(tr)
@@ -255,7 +256,7 @@ func TestTransactionWrongSequence2(t *testing.T) {
 	assert.NotNil(t, res, "In progress transactions MUST return a response to a well-formed MAIL command")
 	assert.Equal(t, smtpd.CodeBadSequence, res.Code, "In progress transactions MUST return response code 503 to a well-formed MAIL command")
 	assert.Equal(t, smtpd.TSInProgress, tr.State, "In progress transactions MUST NOT mutate state after a well-formed MAIL command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", MailCommand.FullCmd, "503 Bad sequence of commands"}, tr.History, "In progress transactions MUST update their history after a well-formed MAIL command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", MailCommand.FullCmd, "503 Bad sequence of commands"}, lines(tr.History), "In progress transactions MUST update their history after a well-formed MAIL command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com"}}, tr.Mail, "In progress transactions MUST NOT update the mail after a well-formed MAIL command")
 
 	fmt.Println(tr)
@@ -272,7 +273,7 @@ func TestTransactionMissingRecipient(t *testing.T) {
 	assert.NotNil(t, res, "In progress transaction with no recipients MUST return a response to a well-formed DATA command")
 	assert.Equal(t, smtpd.CodeBadSequence, res.Code, "In progress transaction with no recipients MUST return response code 503 to a well-formed DATA command")
 	assert.Equal(t, smtpd.TSInProgress, tr.State, "In progress transaction with no recipients MUST NOT mutate state after a well-formed DATA command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", DataCommand.FullCmd, "503 Bad sequence of commands"}, tr.History, "In progress transactions with no recipients MUST update their history after a well-formed DATA command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", DataCommand.FullCmd, "503 Bad sequence of commands"}, lines(tr.History), "In progress transactions with no recipients MUST update their history after a well-formed DATA command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com"}}, tr.Mail, "In progress transactions with no recipients MUST NOT update the mail after a well-formed DATA command")
 
 	fmt.Println(tr)
@@ -285,7 +286,7 @@ func TestTransactionUnexpectedData(t *testing.T) {
 	assert.Error(t, err, "Initiated transaction MUST return an error after an attempt to transfer data")
 	assert.Nil(t, res, "Initiated transaction MUST NO return a response after an attempt to transfer data")
 	assert.Equal(t, smtpd.TSInitiated, tr.State, "Initiated transactions MUST NOT mutate state after an attempt to transfer data")
-	assert.Empty(t, tr.History, "Initiated transactions MUST NOT update their history after an attempt to transfer data")
+	assert.Empty(t, lines(tr.History), "Initiated transactions MUST NOT update their history after an attempt to transfer data")
 	assert.Empty(t, tr.Mail, "Initiated transactions MUST NOT update the mail after an attempt to transfer data")
 
 	fmt.Println(tr)
@@ -307,28 +308,28 @@ func TestTransactionUnexpectedCommand(t *testing.T) {
 	assert.Error(t, err, "Data transactions MUST return an error after a well-formed MAIL command")
 	assert.Nil(t, res, "Data transactions MUST NOT return a response after a well-formed MAIL command")
 	assert.Equal(t, smtpd.TSData, tr.State, "Data transactions MUST NOT mutate state after a well-formed MAIL command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, tr.History, "Data transactions MUST NOT update their history after a well-formed MAIL command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, lines(tr.History), "Data transactions MUST NOT update their history after a well-formed MAIL command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}}, tr.Mail, "Data transactions MUST NOT update the mail after a well-formed MAIL command")
 
 	res, err = tr.Process(&RcptCommand)
 	assert.Error(t, err, "Data transactions MUST return an error after a well-formed RCPT command")
 	assert.Nil(t, res, "Data transactions MUST NOT return a response after a well-formed RCPT command")
 	assert.Equal(t, smtpd.TSData, tr.State, "Data transactions MUST NOT mutate state after a well-formed RCPT command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, tr.History, "Data transactions MUST NOT update their history after a well-formed RCPT command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, lines(tr.History), "Data transactions MUST NOT update their history after a well-formed RCPT command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}}, tr.Mail, "Data transactions MUST NOT update the mail after a well-formed RCPT command")
 
 	res, err = tr.Process(&DataCommand)
 	assert.Error(t, err, "Data transactions MUST return an error after a well-formed DATA command")
 	assert.Nil(t, res, "Data transactions MUST NOT return a response after a well-formed DATA command")
 	assert.Equal(t, smtpd.TSData, tr.State, "Data transactions MUST NOT mutate state after a well-formed DATA command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, tr.History, "Data transactions MUST NOT update their history after a well-formed DATA command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, lines(tr.History), "Data transactions MUST NOT update their history after a well-formed DATA command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}}, tr.Mail, "Data transactions MUST NOT update the mail after a well-formed DATA command")
 
 	res, err = tr.Process(&OtherCommand)
 	assert.Error(t, err, "Data transactions MUST return an error after any unknown command")
 	assert.Nil(t, res, "Data transactions MUST NOT return a response after any unknown command")
 	assert.Equal(t, smtpd.TSData, tr.State, "Data transactions MUST NOT mutate state after any unknown command")
-	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, tr.History, "Data transactions MUST NOT update their history after any unknown command")
+	assert.Equal(t, []string{MailCommand.FullCmd, "250 OK", RcptCommand.FullCmd, "250 OK", DataCommand.FullCmd, "354 Start mail input; end with <CRLF>.<CRLF>"}, lines(tr.History), "Data transactions MUST NOT update their history after any unknown command")
 	assert.Equal(t, smtpd.Mail{Envelope: smtpd.Envelope{Sender: "sender@example.com", Recipients: []string{"recipient@example.com"}}}, tr.Mail, "Data transactions MUST NOT update the mail after any unknown command")
 
 	fmt.Println(tr)
@@ -367,3 +368,33 @@ func TestTransactionInvalidState(t *testing.T) {
 
 	fmt.Println(tr)
 }
+
+// lines returns the lines of the history without timestamps.
+func lines(history []smtpd.HistoryEntry) []string {
+	res := []string{}
+	for _, entry := range history {
+		res = append(res, entry.Line)
+	}
+	return res
+}
+
+func TestTransactionTimestamps(t *testing.T) {
+	before := time.Now()
+	tr := smtpd.NewTransaction()
+	assert.True(t, tr.Received.IsZero(), "A newly created transaction MUST NOT have a reception time")
+
+	_, err := tr.Process(&MailCommand)
+	assert.NoError(t, err)
+	_, err = tr.Process(&RcptCommand)
+	assert.NoError(t, err)
+	_, err = tr.Process(&DataCommand)
+	assert.NoError(t, err)
+	_, err = tr.Data(MailData)
+	assert.NoError(t, err)
+
+	assert.False(t, tr.Received.Before(before), "Completed transactions MUST have a reception time")
+	for _, entry := range tr.History {
+		assert.False(t, entry.Time.Before(before), "Every history entry MUST be timestamped")
+		assert.False(t, entry.Time.After(tr.Received), "Every history entry MUST be timestamped")
+	}
+}
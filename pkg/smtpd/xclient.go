@@ -0,0 +1,168 @@
+// Copyright (C) 2019  Adrien Aury
+//
+// This file is part of Mailmock.
+//
+// Mailmock is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Mailmock is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Mailmock.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Linking this library statically or dynamically with other modules is
+// making a combined work based on this library.  Thus, the terms and
+// conditions of the GNU General Public License cover the whole
+// combination.
+//
+// As a special exception, the copyright holders of this library give you
+// permission to link this library with independent modules to produce an
+// executable, regardless of the license terms of these independent
+// modules, and to copy and distribute the resulting executable under
+// terms of your choice, provided that you also meet, for each linked
+// independent module, the terms and conditions of the license of that
+// module.  An independent module is a module which is not derived from
+// or based on this library.  If you modify this library, you may extend
+// this exception to your version of the library, but you are not
+// obligated to do so.  If you do not wish to do so, delete this
+// exception statement from your version.
+
+package smtpd
+
+import (
+	"encoding/hex"
+	"net"
+	"strings"
+
+	"github.com/adrienaury/mailmock/internal/log"
+)
+
+// Attributes accepted by the XCLIENT and XFORWARD commands of Postfix.
+var (
+	xclientAttributes  = []string{"NAME", "ADDR", "PORT", "PROTO", "HELO", "LOGIN"}
+	xforwardAttributes = []string{"NAME", "ADDR", "PORT", "PROTO", "HELO", "IDENT", "SOURCE"}
+)
+
+// parseAttributes parses arguments like "ADDR=192.0.2.1", values are encoded as xtext (RFC 3461 §4).
+// The values "[UNAVAILABLE]" and "[TEMPUNAVAIL]" are returned as empty strings.
+func parseAttributes(args []string, names []string) (map[string]string, bool) {
+	attributes := map[string]string{}
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || !contains(names, strings.ToUpper(parts[0])) {
+			return nil, false
+		}
+		value, ok := decodeXtext(parts[1])
+		if !ok {
+			return nil, false
+		}
+		if value == "[UNAVAILABLE]" || value == "[TEMPUNAVAIL]" {
+			value = ""
+		}
+		attributes[strings.ToUpper(parts[0])] = value
+	}
+	return attributes, true
+}
+
+// decodeXtext decodes "+XX" hexadecimal sequences.
+func decodeXtext(s string) (string, bool) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '+' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", false
+		}
+		b, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", false
+		}
+		sb.Write(b)
+		i += 2
+	}
+	return sb.String(), true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// xclient overrides the attributes of the client with the XCLIENT command of Postfix,
+// the session starts over with a greeting, as if the overridden client had just connected.
+func (s *Session) xclient(args []string) *Response {
+	if !s.xclientTrusted {
+		return s.r(XClientDenied)
+	}
+	if s.State == SSBusy {
+		return s.r(BadSequence)
+	}
+	attributes, ok := parseAttributes(args, xclientAttributes)
+	if !ok {
+		return s.r(XClientAttribute)
+	}
+	if s.XClient == nil {
+		s.XClient = map[string]string{}
+	}
+	for name, value := range attributes {
+		s.XClient[name] = value
+	}
+
+	host, port, err := net.SplitHostPort(s.RemoteAddr)
+	if err != nil {
+		host, port = "", "" // client of a Unix domain socket, its address is kept unless ADDR is given
+	}
+	if addr, ok := attributes["ADDR"]; ok {
+		host = addr
+		if strings.HasPrefix(strings.ToUpper(addr), "IPV6:") {
+			host = addr[len("IPV6:"):]
+		}
+	}
+	if p, ok := attributes["PORT"]; ok {
+		port = p
+	}
+	switch {
+	case host != "" && port != "":
+		s.RemoteAddr = net.JoinHostPort(host, port)
+	case host != "":
+		s.RemoteAddr = host
+	}
+	s.State = SSInitiated
+	s.Client, s.Extended, s.User = s.XClient["HELO"], false, s.XClient["LOGIN"]
+	s.forward = nil
+	s.logger.Info("Client attributes overridden with XCLIENT", log.Fields{"xclient": attributes})
+	return s.greeting()
+}
+
+// xforward records the attributes of the original client for the next transaction,
+// with the XFORWARD command of Postfix.
+func (s *Session) xforward(args []string) *Response {
+	if !s.xclientTrusted {
+		return s.r(XClientDenied)
+	}
+	if s.State == SSBusy {
+		return s.r(BadSequence)
+	}
+	attributes, ok := parseAttributes(args, xforwardAttributes)
+	if !ok {
+		return s.r(XClientAttribute)
+	}
+	if s.forward == nil {
+		s.forward = map[string]string{}
+	}
+	for name, value := range attributes {
+		s.forward[name] = value
+	}
+	return s.r(Success)
+}
@@ -0,0 +1,158 @@
+package smtpd_test
+
+import (
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adrienaury/mailmock/pkg/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveUnix starts a server listening on a Unix domain socket and returns its path and the closed sessions.
+func serveUnix(t *testing.T, options smtpd.Options) (string, chan *smtpd.Session) {
+	sessions := make(chan *smtpd.Session, 1)
+	var sh smtpd.SessionHandler = func(s *smtpd.Session, e smtpd.SessionEvent) {
+		if e == smtpd.SEClosed {
+			sessions <- s
+		}
+	}
+	path := filepath.Join(t.TempDir(), "smtp.sock")
+	ln, err := net.Listen("unix", path)
+	assert.NoError(t, err, "")
+	stop := make(chan struct{})
+	srv := smtpd.NewUnixServer("mockmail-unix", path, nil, nil)
+	srv.SetOptions(options)
+	srv.SetSessionHandler(&sh)
+	go func() { _ = srv.Serve(ln, stop) }()
+	t.Cleanup(func() { close(stop) })
+	return path, sessions
+}
+
+// xclientSession sends each command and checks the code of the reply, and returns the session recorded by the server.
+func xclientSession(t *testing.T, network string, addr string, sessions chan *smtpd.Session, commands [][2]interface{}) *smtpd.Session {
+	conn, err := net.Dial(network, addr)
+	assert.NoError(t, err, "")
+	c := textproto.NewConn(conn)
+	defer c.Close()
+	_, _, err = c.ReadResponse(220)
+	assert.NoError(t, err, "")
+	for _, command := range commands {
+		assert.NoError(t, c.PrintfLine("%s", command[0]), "")
+		_, msg, err := c.ReadResponse(command[1].(int))
+		assert.NoError(t, err, "Unexpected reply to %v : %v", command[0], msg)
+	}
+
+	select {
+	case s := <-sessions:
+		return s
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Session not closed")
+	}
+	return nil
+}
+
+func TestXClient(t *testing.T) {
+	addr, sessions := serveOptions(t, smtpd.Options{XClientTrusted: trusted(t, "127.0.0.0/8"), AuthRequired: true})
+
+	s := xclientSession(t, "tcp", addr, sessions, [][2]interface{}{
+		{"EHLO relay.example.com", 250},
+		{"XCLIENT ADDR=192.0.2.1 PORT=4242 HELO=client.example.com LOGIN=alice+40example.com", 220},
+		{"EHLO relay.example.com", 250},
+		{"MAIL FROM:<alice@example.com>", 250},
+		{"RCPT TO:<bob@example.com>", 250},
+		{"DATA", 354},
+		{"Subject: test\r\n\r\nHello\r\n.", 250},
+		{"QUIT", 221},
+	})
+	assert.Contains(t, s.Transcript, "S: 250-XCLIENT NAME ADDR PORT PROTO HELO LOGIN", "")
+	assert.Equal(t, "192.0.2.1:4242", s.RemoteAddr, "")
+	assert.Equal(t, "client.example.com", s.Client, "The HELO name given with XCLIENT MUST be kept")
+	assert.Equal(t, "alice@example.com", s.User, "")
+	assert.Equal(t, map[string]string{"ADDR": "192.0.2.1", "PORT": "4242", "HELO": "client.example.com", "LOGIN": "alice@example.com"}, s.XClient, "")
+	if assert.Len(t, s.Transactions, 1, "") {
+		assert.Equal(t, "192.0.2.1:4242", s.Transactions[0].RemoteAddr, "")
+		assert.Equal(t, "alice@example.com", s.Transactions[0].User, "The login given with XCLIENT MUST satisfy the authentication")
+	}
+
+	s = xclientSession(t, "tcp", addr, sessions, [][2]interface{}{
+		{"XCLIENT ADDR=IPV6:2001:db8::1 LOGIN=[UNAVAILABLE]", 220},
+		{"XCLIENT FOO=bar", 501},
+		{"XCLIENT ADDR=192.0.2.1+4", 501},
+		{"QUIT", 221},
+	})
+	assert.Regexp(t, `^\[2001:db8::1\]:\d+$`, s.RemoteAddr, "")
+	assert.Equal(t, "", s.User, "")
+}
+
+func TestXForward(t *testing.T) {
+	addr, sessions := serveOptions(t, smtpd.Options{XClientTrusted: trusted(t, "127.0.0.0/8"), AuthRequired: true})
+
+	s := xclientSession(t, "tcp", addr, sessions, [][2]interface{}{
+		{"EHLO relay.example.com", 250},
+		{"XCLIENT LOGIN=relay", 220},
+		{"EHLO relay.example.com", 250},
+		{"XFORWARD NAME=client.example.com ADDR=192.0.2.1", 250},
+		{"XFORWARD HELO=client.example.com", 250},
+		{"MAIL FROM:<alice@example.com>", 250},
+		{"XFORWARD ADDR=192.0.2.2", 503},
+		{"RCPT TO:<bob@example.com>", 250},
+		{"DATA", 354},
+		{"Subject: test\r\n\r\nHello\r\n.", 250},
+		{"MAIL FROM:<alice@example.com>", 250},
+		{"RSET", 250},
+		{"QUIT", 221},
+	})
+	if assert.Len(t, s.Transactions, 2, "") {
+		assert.Equal(t, map[string]string{"NAME": "client.example.com", "ADDR": "192.0.2.1", "HELO": "client.example.com"}, s.Transactions[0].Forwarded, "")
+		assert.Nil(t, s.Transactions[1].Forwarded, "XFORWARD attributes MUST apply to the next transaction only")
+	}
+	assert.Regexp(t, `^127\.0\.0\.1:\d+$`, s.RemoteAddr, "XFORWARD MUST NOT override the client")
+}
+
+func TestXClientUntrusted(t *testing.T) {
+	addr, sessions := serveOptions(t, smtpd.Options{XClientTrusted: trusted(t, "10.0.0.0/8"), AuthRequired: true})
+
+	s := xclientSession(t, "tcp", addr, sessions, [][2]interface{}{
+		{"EHLO relay.example.com", 250},
+		{"XCLIENT ADDR=192.0.2.1", 550},
+		{"XFORWARD ADDR=192.0.2.1", 550},
+		{"QUIT", 221},
+	})
+	assert.Regexp(t, `^127\.0\.0\.1:\d+$`, s.RemoteAddr, "")
+	assert.Nil(t, s.XClient, "")
+	for _, line := range s.Transcript {
+		assert.NotContains(t, line, "250-XCLIENT", "XCLIENT MUST NOT be advertised to untrusted clients")
+	}
+}
+
+func TestXClientUnix(t *testing.T) {
+	path, sessions := serveUnix(t, smtpd.Options{XClientTrusted: trusted(t, "127.0.0.0/8")})
+
+	s := xclientSession(t, "unix", path, sessions, [][2]interface{}{
+		{"XCLIENT HELO=client.example.com", 220},
+		{"QUIT", 221},
+	})
+	assert.NotContains(t, s.RemoteAddr, ":", "The address of the client MUST be kept if not given")
+
+	s = xclientSession(t, "unix", path, sessions, [][2]interface{}{
+		{"XCLIENT ADDR=192.0.2.1", 220},
+		{"QUIT", 221},
+	})
+	assert.Equal(t, "192.0.2.1", s.RemoteAddr, "An address without port MUST NOT end with a colon")
+
+	s = xclientSession(t, "unix", path, sessions, [][2]interface{}{
+		{"XCLIENT ADDR=IPV6:2001:db8::1 PORT=4242", 220},
+		{"QUIT", 221},
+	})
+	assert.Equal(t, "[2001:db8::1]:4242", s.RemoteAddr, "")
+
+	path, sessions = serveUnix(t, smtpd.Options{})
+	s = xclientSession(t, "unix", path, sessions, [][2]interface{}{
+		{"XCLIENT ADDR=192.0.2.1", 550},
+		{"QUIT", 221},
+	})
+	assert.Nil(t, s.XClient, "Clients MUST NOT be trusted if no network is")
+}